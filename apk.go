@@ -0,0 +1,357 @@
+package gadb
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// APKInfo describes an APK file, read from its manifest and resources.
+type APKInfo struct {
+	Package     string
+	VersionCode int64
+	VersionName string
+	MinSDK      int
+	TargetSDK   int
+	// Label is the application label, resolved through resources.arsc
+	// when it is a reference.
+	Label string
+
+	// Split is the split name, e.g. "config.arm64_v8a", or empty for a base APK.
+	Split          string
+	IsFeatureSplit bool
+	// ConfigForSplit names the feature split a configuration split belongs
+	// to, or is empty when it belongs to the base APK.
+	ConfigForSplit string
+
+	// ABIs lists the ABIs the APK ships native libraries for (lib/<abi>/).
+	ABIs []string
+	// LaunchableActivity is the fully qualified name of the first activity
+	// handling MAIN/LAUNCHER, or empty if there is none.
+	LaunchableActivity string
+	Permissions        []string
+}
+
+// InspectAPK reads the metadata of the APK in r, which holds size bytes.
+func InspectAPK(r io.ReaderAt, size int64) (APKInfo, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return APKInfo{}, fmt.Errorf("open apk: %w", err)
+	}
+
+	var manifest, resources []byte
+	abis := map[string]bool{}
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "AndroidManifest.xml":
+			if manifest, err = readZipFile(f); err != nil {
+				return APKInfo{}, err
+			}
+		case f.Name == "resources.arsc":
+			if resources, err = readZipFile(f); err != nil {
+				return APKInfo{}, err
+			}
+		case strings.HasPrefix(f.Name, "lib/"):
+			if parts := strings.Split(f.Name, "/"); len(parts) == 3 && parts[1] != "" {
+				abis[parts[1]] = true
+			}
+		}
+	}
+	if manifest == nil {
+		return APKInfo{}, fmt.Errorf("apk has no AndroidManifest.xml")
+	}
+
+	root, err := parseAXML(manifest)
+	if err != nil {
+		return APKInfo{}, fmt.Errorf("parse manifest: %w", err)
+	}
+	var table *resTable
+	if resources != nil {
+		if table, err = parseResTable(resources); err != nil {
+			return APKInfo{}, fmt.Errorf("parse resources.arsc: %w", err)
+		}
+	}
+
+	info := manifestInfo(root, table)
+	for abi := range abis {
+		info.ABIs = append(info.ABIs, abi)
+	}
+	sort.Strings(info.ABIs)
+	return info, nil
+}
+
+// InspectAPKFile reads the metadata of the APK file at path.
+func InspectAPKFile(path string) (APKInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return APKInfo{}, err
+	}
+	defer func() { _ = f.Close() }()
+	stat, err := f.Stat()
+	if err != nil {
+		return APKInfo{}, err
+	}
+	return InspectAPK(f, stat.Size())
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", f.Name, err)
+	}
+	defer func() { _ = rc.Close() }()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", f.Name, err)
+	}
+	return data, nil
+}
+
+// manifestInfo extracts APKInfo from a decoded manifest.
+func manifestInfo(root *xmlElement, table *resTable) APKInfo {
+	str := func(e *xmlElement, name string) string {
+		if a, ok := e.attr(name); ok {
+			return table.attrString(a)
+		}
+		return ""
+	}
+	num := func(e *xmlElement, name string) int64 {
+		if a, ok := e.attr(name); ok {
+			n, _ := table.attrInt(a)
+			return n
+		}
+		return 0
+	}
+
+	info := APKInfo{
+		Package:        str(root, "package"),
+		VersionName:    str(root, "versionName"),
+		Split:          str(root, "split"),
+		ConfigForSplit: str(root, "configForSplit"),
+		IsFeatureSplit: str(root, "isFeatureSplit") == "true",
+	}
+	info.VersionCode = num(root, "versionCodeMajor")<<32 | int64(uint32(num(root, "versionCode")))
+
+	for _, sdk := range root.children("uses-sdk") {
+		info.MinSDK = int(num(sdk, "minSdkVersion"))
+		info.TargetSDK = int(num(sdk, "targetSdkVersion"))
+		if info.MinSDK == 0 {
+			info.MinSDK = 1
+		}
+		if info.TargetSDK == 0 {
+			info.TargetSDK = info.MinSDK
+		}
+	}
+	for _, perm := range root.children("uses-permission") {
+		if name := str(perm, "name"); name != "" {
+			info.Permissions = append(info.Permissions, name)
+		}
+	}
+
+	for _, app := range root.children("application") {
+		info.Label = str(app, "label")
+		for _, c := range app.Children {
+			if c.Name != "activity" && c.Name != "activity-alias" {
+				continue
+			}
+			if info.LaunchableActivity == "" && isLauncher(c, str) {
+				info.LaunchableActivity = qualifyClassName(info.Package, str(c, "name"))
+			}
+		}
+	}
+	return info
+}
+
+func isLauncher(activity *xmlElement, str func(*xmlElement, string) string) bool {
+	for _, filter := range activity.children("intent-filter") {
+		main, launcher := false, false
+		for _, action := range filter.children("action") {
+			main = main || str(action, "name") == "android.intent.action.MAIN"
+		}
+		for _, category := range filter.children("category") {
+			launcher = launcher || str(category, "name") == "android.intent.category.LAUNCHER"
+		}
+		if main && launcher {
+			return true
+		}
+	}
+	return false
+}
+
+// qualifyClassName expands the short class names a manifest allows,
+// ".Main" and "Main", to fully qualified ones.
+func qualifyClassName(pkg, class string) string {
+	switch {
+	case strings.HasPrefix(class, "."):
+		return pkg + class
+	case class != "" && !strings.Contains(class, "."):
+		return pkg + "." + class
+	}
+	return class
+}
+
+// DeviceSpec holds the device properties that decide which splits of an
+// app are installed.
+type DeviceSpec struct {
+	// ABIs lists the supported ABIs, most preferred first.
+	ABIs []string
+	// Density is the screen density in dpi.
+	Density int
+	SDK     int
+}
+
+// DeviceSpec reads the device's ABIs, screen density and SDK level.
+func (d Device) DeviceSpec() (spec DeviceSpec, err error) {
+	info, err := d.BuildInfo()
+	if err != nil {
+		return DeviceSpec{}, err
+	}
+	spec.ABIs, spec.SDK = info.ABIs, info.SDK
+
+	var density string
+	if density, err = d.GetProp("ro.sf.lcd_density"); err != nil {
+		return DeviceSpec{}, err
+	}
+	if density == "" {
+		// emulators set the density through a qemu property instead
+		if density, err = d.GetProp("qemu.sf.lcd_density"); err != nil {
+			return DeviceSpec{}, err
+		}
+	}
+	spec.Density, _ = strconv.Atoi(density)
+	return
+}
+
+// densityBuckets maps density qualifiers to their dpi.
+var densityBuckets = map[string]int{
+	"ldpi":    120,
+	"mdpi":    160,
+	"tvdpi":   213,
+	"hdpi":    240,
+	"xhdpi":   320,
+	"xxhdpi":  480,
+	"xxxhdpi": 640,
+}
+
+// SelectSplits picks from apks, the base APK and its splits, the ones to
+// install on a device matching spec, and returns their indices. Of the ABI
+// splits it keeps the one for the most preferred ABI the device supports;
+// of the density splits the one for the smallest density at least the
+// device's, or else the largest. All other splits, such as language and
+// feature splits, are kept.
+func SelectSplits(apks []APKInfo, spec DeviceSpec) []int {
+	// split names spell ABIs with underscores: config.arm64_v8a
+	abiRank := map[string]int{}
+	for i, abi := range spec.ABIs {
+		abi = strings.Replace(abi, "-", "_", -1)
+		if _, ok := abiRank[abi]; !ok {
+			abiRank[abi] = i
+		}
+	}
+
+	// best ABI and density split per split group, i.e. per configForSplit
+	type choice struct{ index, rank int }
+	bestABI := map[string]choice{}
+	bestDensity := map[string]choice{}
+	var selected []int
+
+	for i, apk := range apks {
+		config := strings.TrimPrefix(apk.Split, "config.")
+		if apk.Split == "" || config == apk.Split {
+			selected = append(selected, i)
+			continue
+		}
+		if abiSplits[config] {
+			rank, ok := abiRank[config]
+			if best, seen := bestABI[apk.ConfigForSplit]; ok && (!seen || rank < best.rank) {
+				bestABI[apk.ConfigForSplit] = choice{i, rank}
+			}
+			continue
+		}
+		if dpi, ok := densityBuckets[config]; ok {
+			// rank splits at or above the device density first, the
+			// smallest of those winning; below it, the largest wins
+			rank := dpi - spec.Density
+			if rank < 0 {
+				rank = 1<<16 - dpi
+			}
+			if best, seen := bestDensity[apk.ConfigForSplit]; !seen || rank < best.rank {
+				bestDensity[apk.ConfigForSplit] = choice{i, rank}
+			}
+			continue
+		}
+		selected = append(selected, i)
+	}
+
+	for _, c := range bestABI {
+		selected = append(selected, c.index)
+	}
+	for _, c := range bestDensity {
+		selected = append(selected, c.index)
+	}
+	sort.Ints(selected)
+	return selected
+}
+
+// abiSplits lists the ABIs Android supports, as spelled in split names.
+var abiSplits = map[string]bool{
+	"armeabi":     true,
+	"armeabi_v7a": true,
+	"arm64_v8a":   true,
+	"x86":         true,
+	"x86_64":      true,
+	"mips":        true,
+	"mips64":      true,
+	"riscv64":     true,
+}
+
+// InstallAPKFiles installs the APK files at paths, a base APK optionally
+// followed by its splits. Only the splits matching the device's ABI and
+// screen density are installed.
+func (d Device) InstallAPKFiles(ctx context.Context, paths []string, opts InstallOptions) error {
+	infos := make([]APKInfo, len(paths))
+	for i, path := range paths {
+		info, err := InspectAPKFile(path)
+		if err != nil {
+			return fmt.Errorf("inspect %s: %w", path, err)
+		}
+		infos[i] = info
+	}
+
+	selected := make([]int, len(paths))
+	for i := range selected {
+		selected[i] = i
+	}
+	if len(paths) > 1 {
+		spec, err := d.DeviceSpec()
+		if err != nil {
+			return err
+		}
+		selected = SelectSplits(infos, spec)
+	}
+
+	var apks []APKSource
+	for _, i := range selected {
+		f, err := os.Open(paths[i])
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		apks = append(apks, APKSource{Name: filepath.Base(paths[i]), Reader: f, Size: stat.Size()})
+	}
+	if len(apks) == 1 {
+		return d.Install(ctx, apks[0].Reader, apks[0].Size, opts)
+	}
+	return d.InstallMultiple(ctx, apks, opts)
+}
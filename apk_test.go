@@ -0,0 +1,271 @@
+package gadb
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+// resChunkBytes frames body as a chunk whose header, excluding the common
+// type/headerSize/size fields, is header.
+func resChunkBytes(typ uint16, header, body []byte) []byte {
+	b := make([]byte, 8, 8+len(header)+len(body))
+	binary.LittleEndian.PutUint16(b, typ)
+	binary.LittleEndian.PutUint16(b[2:], uint16(8+len(header)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(8+len(header)+len(body)))
+	return append(append(b, header...), body...)
+}
+
+func le(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// utf16StringPool encodes a UTF-16 string pool.
+func utf16StringPool(strs []string) []byte {
+	var offsets, data []byte
+	for _, s := range strs {
+		offsets = append(offsets, le(uint32(len(data)))...)
+		units := utf16.Encode([]rune(s))
+		data = append(data, le(uint16(len(units)), units, uint16(0))...)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	header := le(uint32(len(strs)), uint32(0), uint32(0), uint32(28+len(offsets)), uint32(0))
+	return resChunkBytes(resStringPoolType, header, append(offsets, data...))
+}
+
+type testAttr struct {
+	name     string
+	dataType uint8
+	data     uint32
+	raw      string
+}
+
+// axmlBuilder encodes binary XML documents.
+type axmlBuilder struct {
+	strings []string
+	resIDs  []uint32
+	body    []byte
+}
+
+// attrName interns an attribute name with a resource ID. Those must come
+// first in the pool, matching the resource map.
+func (b *axmlBuilder) attrName(name string, id uint32) {
+	b.strings = append(b.strings, name)
+	b.resIDs = append(b.resIDs, id)
+}
+
+func (b *axmlBuilder) str(s string) uint32 {
+	for i, have := range b.strings {
+		if have == s && i >= len(b.resIDs) {
+			return uint32(i)
+		}
+	}
+	b.strings = append(b.strings, s)
+	return uint32(len(b.strings) - 1)
+}
+
+func (b *axmlBuilder) nameIndex(name string) uint32 {
+	for i := range b.resIDs {
+		if b.strings[i] == name {
+			return uint32(i)
+		}
+	}
+	return b.str(name)
+}
+
+func (b *axmlBuilder) start(name string, attrs ...testAttr) {
+	ext := le(uint32(0xffffffff), b.str(name), uint16(20), uint16(20), uint16(len(attrs)), uint16(0), uint16(0), uint16(0))
+	for _, a := range attrs {
+		raw := uint32(0xffffffff)
+		if a.raw != "" {
+			raw = b.str(a.raw)
+		}
+		data := a.data
+		if a.dataType == resValueString {
+			data = b.str(a.raw)
+		}
+		ext = append(ext, le(uint32(0xffffffff), b.nameIndex(a.name), raw, uint16(8), uint8(0), a.dataType, data)...)
+	}
+	b.body = append(b.body, resChunkBytes(resXMLStartElement, le(uint32(1), uint32(0xffffffff)), ext)...)
+}
+
+func (b *axmlBuilder) end(name string) {
+	b.body = append(b.body, resChunkBytes(resXMLEndElement, le(uint32(1), uint32(0xffffffff)), le(uint32(0xffffffff), b.str(name)))...)
+}
+
+func (b *axmlBuilder) bytes() []byte {
+	var resMap []byte
+	for _, id := range b.resIDs {
+		resMap = append(resMap, le(id)...)
+	}
+	body := append(utf16StringPool(b.strings), resChunkBytes(resXMLResourceMapType, nil, resMap)...)
+	return resChunkBytes(resXMLType, nil, append(body, b.body...))
+}
+
+func str(name, value string) testAttr {
+	return testAttr{name: name, dataType: resValueString, raw: value}
+}
+
+func num(name string, n uint32) testAttr {
+	return testAttr{name: name, dataType: resValueIntDec, data: n}
+}
+
+// testResTable encodes a resources.arsc holding the single string
+// resource 0x7f010000 = value, with an override for another configuration.
+func testResTable(value string) []byte {
+	typeChunk := func(config byte, entry uint32) []byte {
+		cfg := make([]byte, 64)
+		binary.LittleEndian.PutUint32(cfg, 64)
+		cfg[8] = config // language
+		header := append(le(uint8(1), uint8(0), uint16(0), uint32(1), uint32(84+4)), cfg...)
+		body := append(le(uint32(0)), le(uint16(8), uint16(0), uint32(0), uint16(8), uint8(0), uint8(resValueString), entry)...)
+		return resChunkBytes(resTableTypeType, header, body)
+	}
+	return resTableBytes([]string{value, "other"}, append(typeChunk('f', 1), typeChunk(0, 0)...))
+}
+
+// resTableBytes encodes a resources.arsc with the given strings and the
+// type chunks of package 0x7f.
+func resTableBytes(strs []string, typeChunks []byte) []byte {
+	pkgHeader := append(le(uint32(0x7f)), make([]byte, 256+5*4)...)
+	pkg := resChunkBytes(resTablePackageType, pkgHeader, typeChunks)
+	return resChunkBytes(resTableType, le(uint32(1)), append(utf16StringPool(strs), pkg...))
+}
+
+func testManifest() []byte {
+	var b axmlBuilder
+	b.attrName("versionCode", 0x0101021b)
+	b.attrName("", 0x0101020c) // stripped name: minSdkVersion
+	b.start("manifest", str("package", "com.example.app"), num("versionCode", 42), str("versionName", "1.2"))
+	b.start("uses-sdk", num("", 21), num("targetSdkVersion", 33))
+	b.end("uses-sdk")
+	b.start("uses-permission", str("name", "android.permission.INTERNET"))
+	b.end("uses-permission")
+	b.start("application", testAttr{name: "label", dataType: resValueReference, data: 0x7f010000})
+	b.start("activity", str("name", ".Settings"))
+	b.end("activity")
+	b.start("activity-alias", str("name", "Launcher"))
+	b.start("intent-filter")
+	b.start("action", str("name", "android.intent.action.MAIN"))
+	b.end("action")
+	b.start("category", str("name", "android.intent.category.LAUNCHER"))
+	b.end("category")
+	b.end("intent-filter")
+	b.end("activity-alias")
+	b.end("application")
+	b.end("manifest")
+	return b.bytes()
+}
+
+func testAPK(t *testing.T, files map[string][]byte) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestInspectAPK(t *testing.T) {
+	apk := testAPK(t, map[string][]byte{
+		"AndroidManifest.xml":         testManifest(),
+		"resources.arsc":              testResTable("Example"),
+		"lib/arm64-v8a/libfoo.so":     {0},
+		"lib/armeabi-v7a/libfoo.so":   {0},
+		"classes.dex":                 {0},
+		"assets/lib/x86/not-a-lib.so": {0},
+	})
+	info, err := InspectAPK(apk, apk.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := APKInfo{
+		Package:            "com.example.app",
+		VersionCode:        42,
+		VersionName:        "1.2",
+		MinSDK:             21,
+		TargetSDK:          33,
+		Label:              "Example",
+		ABIs:               []string{"arm64-v8a", "armeabi-v7a"},
+		LaunchableActivity: "com.example.app.Launcher",
+		Permissions:        []string{"android.permission.INTERNET"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("InspectAPK() = %+v\nwant %+v", info, want)
+	}
+
+	if _, err = InspectAPK(bytes.NewReader([]byte("not a zip")), 9); err == nil {
+		t.Error("InspectAPK accepted a non-zip file")
+	}
+}
+
+func Test_parseResTable_malformed(t *testing.T) {
+	cfg := le(uint32(64), make([]byte, 60))
+	entry := le(uint16(8), uint16(0), uint32(0), uint16(8), uint8(0), uint8(resValueString), uint32(0))
+	for name, typeChunk := range map[string][]byte{
+		"no header":        resChunkBytes(resTableTypeType, nil, nil),
+		"truncated config": resChunkBytes(resTableTypeType, le(uint8(1), uint8(0), uint16(0), uint32(1), uint32(28), uint32(64)), le(uint32(0))),
+	} {
+		_, err := parseResTable(resTableBytes([]string{"x"}, typeChunk))
+		if !errors.Is(err, errMalformedResource) {
+			t.Errorf("%s: parseResTable() = %v, want %v", name, err, errMalformedResource)
+		}
+	}
+
+	// an entry count beyond the chunk is bounded by the entry index
+	header := append(le(uint8(1), uint8(0), uint16(0), uint32(0xffffffff), uint32(84+4)), cfg...)
+	table, err := parseResTable(resTableBytes([]string{"x"}, resChunkBytes(resTableTypeType, header, append(le(uint32(0)), entry...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := table.values[0x7f010000]; !ok || v.dataType != resValueString {
+		t.Errorf("values = %v", table.values)
+	}
+}
+
+func TestSelectSplits(t *testing.T) {
+	apks := []APKInfo{
+		{Package: "com.example.app"},
+		{Split: "config.armeabi_v7a"},
+		{Split: "config.arm64_v8a"},
+		{Split: "config.x86_64"},
+		{Split: "config.mdpi"},
+		{Split: "config.xhdpi"},
+		{Split: "config.xxhdpi"},
+		{Split: "config.en"},
+		{Split: "feature", IsFeatureSplit: true},
+		{Split: "config.arm64_v8a", ConfigForSplit: "feature"},
+	}
+	tests := []struct {
+		spec DeviceSpec
+		want []int
+	}{
+		{DeviceSpec{ABIs: []string{"arm64-v8a", "armeabi-v7a"}, Density: 420}, []int{0, 2, 6, 7, 8, 9}},
+		{DeviceSpec{ABIs: []string{"x86_64", "x86"}, Density: 320}, []int{0, 3, 5, 7, 8}},
+		{DeviceSpec{ABIs: []string{"armeabi-v7a"}, Density: 640}, []int{0, 1, 6, 7, 8}},
+	}
+	for _, tt := range tests {
+		if got := SelectSplits(apks, tt.spec); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SelectSplits(%+v) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
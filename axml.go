@@ -0,0 +1,482 @@
+package gadb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Chunk types of Android's binary resource formats (ResourceTypes.h).
+const (
+	resStringPoolType     = 0x0001
+	resTableType          = 0x0002
+	resXMLType            = 0x0003
+	resXMLStartElement    = 0x0102
+	resXMLEndElement      = 0x0103
+	resXMLResourceMapType = 0x0180
+	resTablePackageType   = 0x0200
+	resTableTypeType      = 0x0201
+)
+
+// Res_value data types.
+const (
+	resValueReference = 0x01
+	resValueString    = 0x03
+	resValueIntDec    = 0x10
+	resValueIntHex    = 0x11
+	resValueBoolean   = 0x12
+)
+
+var errMalformedResource = errors.New("malformed binary resource")
+
+type resChunk struct {
+	typ        uint16
+	headerSize int
+	data       []byte // the whole chunk, header included
+}
+
+// readChunk returns the chunk at the start of b.
+func readChunk(b []byte) (resChunk, error) {
+	if len(b) < 8 {
+		return resChunk{}, errMalformedResource
+	}
+	c := resChunk{
+		typ:        binary.LittleEndian.Uint16(b),
+		headerSize: int(binary.LittleEndian.Uint16(b[2:])),
+	}
+	size := int(binary.LittleEndian.Uint32(b[4:]))
+	if size < 8 || size > len(b) || c.headerSize < 8 || c.headerSize > size {
+		return resChunk{}, errMalformedResource
+	}
+	c.data = b[:size]
+	return c, nil
+}
+
+// forEachChunk calls fn for each chunk laid out back to back in b.
+func forEachChunk(b []byte, fn func(resChunk) error) error {
+	for len(b) > 0 {
+		c, err := readChunk(b)
+		if err != nil {
+			return err
+		}
+		if err = fn(c); err != nil {
+			return err
+		}
+		b = b[len(c.data):]
+	}
+	return nil
+}
+
+func (c resChunk) u16(off int) uint16 {
+	if off+2 > len(c.data) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(c.data[off:])
+}
+
+func (c resChunk) u32(off int) uint32 {
+	if off+4 > len(c.data) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(c.data[off:])
+}
+
+type stringPool []string
+
+func (p stringPool) get(i uint32) string {
+	if uint64(i) >= uint64(len(p)) {
+		return ""
+	}
+	return p[i]
+}
+
+// parseStringPool decodes a ResStringPool chunk.
+func parseStringPool(c resChunk) (stringPool, error) {
+	count := int(c.u32(8))
+	utf8 := c.u32(16)&(1<<8) != 0
+	stringsStart := int(c.u32(20))
+	if c.headerSize+count*4 > len(c.data) || stringsStart > len(c.data) {
+		return nil, errMalformedResource
+	}
+
+	pool := make(stringPool, count)
+	for i := range pool {
+		off := stringsStart + int(c.u32(c.headerSize+i*4))
+		if off >= len(c.data) {
+			return nil, errMalformedResource
+		}
+		var err error
+		if utf8 {
+			pool[i], err = decodeUTF8PoolString(c.data[off:])
+		} else {
+			pool[i], err = decodeUTF16PoolString(c.data[off:])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+func decodeUTF8PoolString(b []byte) (string, error) {
+	// the UTF-16 length comes first and is of no use here
+	_, b, err := poolLength8(b)
+	if err != nil {
+		return "", err
+	}
+	n, b, err := poolLength8(b)
+	if err != nil || n > len(b) {
+		return "", errMalformedResource
+	}
+	return string(b[:n]), nil
+}
+
+func poolLength8(b []byte) (int, []byte, error) {
+	if len(b) < 1 {
+		return 0, nil, errMalformedResource
+	}
+	if b[0]&0x80 == 0 {
+		return int(b[0]), b[1:], nil
+	}
+	if len(b) < 2 {
+		return 0, nil, errMalformedResource
+	}
+	return int(b[0]&0x7f)<<8 | int(b[1]), b[2:], nil
+}
+
+func decodeUTF16PoolString(b []byte) (string, error) {
+	if len(b) < 2 {
+		return "", errMalformedResource
+	}
+	n := int(binary.LittleEndian.Uint16(b))
+	b = b[2:]
+	if n&0x8000 != 0 {
+		if len(b) < 2 {
+			return "", errMalformedResource
+		}
+		n = (n&0x7fff)<<16 | int(binary.LittleEndian.Uint16(b))
+		b = b[2:]
+	}
+	if n*2 > len(b) {
+		return "", errMalformedResource
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+type resValue struct {
+	dataType uint8
+	data     uint32
+}
+
+type xmlAttr struct {
+	Name string
+	// ResID is the attribute's resource ID, e.g. 0x0101021b for
+	// android:versionCode, or 0 for attributes without one.
+	ResID uint32
+	Raw   string
+	Value resValue
+}
+
+type xmlElement struct {
+	Name     string
+	Attrs    []xmlAttr
+	Children []*xmlElement
+}
+
+// androidAttrNames names the framework attributes looked up by ID, for
+// manifests whose attribute name strings have been stripped.
+var androidAttrNames = map[uint32]string{
+	0x01010001: "label",
+	0x01010003: "name",
+	0x0101020c: "minSdkVersion",
+	0x0101021b: "versionCode",
+	0x0101021c: "versionName",
+	0x01010270: "targetSdkVersion",
+	0x01010591: "isFeatureSplit",
+	0x01010571: "versionCodeMajor",
+}
+
+func (e *xmlElement) attr(name string) (xmlAttr, bool) {
+	for _, a := range e.Attrs {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return xmlAttr{}, false
+}
+
+// children returns the direct children named name.
+func (e *xmlElement) children(name string) []*xmlElement {
+	var found []*xmlElement
+	for _, c := range e.Children {
+		if c.Name == name {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// parseAXML decodes a binary XML document such as a compiled
+// AndroidManifest.xml and returns its root element.
+func parseAXML(data []byte) (*xmlElement, error) {
+	doc, err := readChunk(data)
+	if err != nil || doc.typ != resXMLType {
+		return nil, fmt.Errorf("not a binary XML document: %w", errMalformedResource)
+	}
+
+	var pool stringPool
+	var resMap []uint32
+	var root *xmlElement
+	var stack []*xmlElement
+
+	err = forEachChunk(doc.data[doc.headerSize:], func(c resChunk) error {
+		switch c.typ {
+		case resStringPoolType:
+			var err error
+			pool, err = parseStringPool(c)
+			return err
+		case resXMLResourceMapType:
+			for off := c.headerSize; off+4 <= len(c.data); off += 4 {
+				resMap = append(resMap, c.u32(off))
+			}
+		case resXMLStartElement:
+			el, err := parseStartElement(c, pool, resMap)
+			if err != nil {
+				return err
+			}
+			if len(stack) == 0 {
+				if root != nil {
+					return errMalformedResource
+				}
+				root = el
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, el)
+			}
+			stack = append(stack, el)
+		case resXMLEndElement:
+			if len(stack) == 0 {
+				return errMalformedResource
+			}
+			stack = stack[:len(stack)-1]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("binary XML has no root element: %w", errMalformedResource)
+	}
+	return root, nil
+}
+
+func parseStartElement(c resChunk, pool stringPool, resMap []uint32) (*xmlElement, error) {
+	ext := c.headerSize
+	el := &xmlElement{Name: pool.get(c.u32(ext + 4))}
+	attrStart := int(c.u16(ext + 8))
+	attrSize := int(c.u16(ext + 10))
+	attrCount := int(c.u16(ext + 12))
+	if attrSize < 20 || ext+attrStart+attrCount*attrSize > len(c.data) {
+		return nil, errMalformedResource
+	}
+
+	for i := 0; i < attrCount; i++ {
+		off := ext + attrStart + i*attrSize
+		nameIdx := c.u32(off + 4)
+		a := xmlAttr{
+			Name: pool.get(nameIdx),
+			Value: resValue{
+				dataType: c.data[off+15],
+				data:     c.u32(off + 16),
+			},
+		}
+		if raw := c.u32(off + 8); raw != 0xffffffff {
+			a.Raw = pool.get(raw)
+		} else if a.Value.dataType == resValueString {
+			a.Raw = pool.get(a.Value.data)
+		}
+		if int(nameIdx) < len(resMap) {
+			a.ResID = resMap[nameIdx]
+			if name, ok := androidAttrNames[a.ResID]; ok && a.Name == "" {
+				a.Name = name
+			}
+		}
+		el.Attrs = append(el.Attrs, a)
+	}
+	return el, nil
+}
+
+// resTable holds the values of resources.arsc needed to resolve references.
+type resTable struct {
+	strings stringPool
+	values  map[uint32]resValue
+}
+
+// parseResTable decodes a resources.arsc file. For each resource it keeps
+// the value of the default configuration, or else the first one found.
+func parseResTable(data []byte) (*resTable, error) {
+	table, err := readChunk(data)
+	if err != nil || table.typ != resTableType {
+		return nil, fmt.Errorf("not a resource table: %w", errMalformedResource)
+	}
+	t := &resTable{values: map[uint32]resValue{}}
+	err = forEachChunk(table.data[table.headerSize:], func(c resChunk) error {
+		switch c.typ {
+		case resStringPoolType:
+			var err error
+			t.strings, err = parseStringPool(c)
+			return err
+		case resTablePackageType:
+			pkgID := c.u32(8)
+			return forEachChunk(c.data[c.headerSize:], func(tc resChunk) error {
+				if tc.typ == resTableTypeType {
+					return t.addTypeChunk(pkgID, tc)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Flags of ResTable_type and ResTable_entry.
+const (
+	resTypeFlagSparse   = 0x01
+	resTypeFlagOffset16 = 0x02
+	resEntryFlagComplex = 0x0001
+	resEntryFlagCompact = 0x0008
+)
+
+// addTypeChunk records the values of a ResTable_type chunk, whose header
+// is 20 bytes followed by a ResTable_config starting with its own size.
+func (t *resTable) addTypeChunk(pkgID uint32, c resChunk) error {
+	configSize := c.u32(20)
+	if c.headerSize < 24 || configSize < 4 || uint64(c.headerSize) < 20+uint64(configSize) {
+		return fmt.Errorf("resource type chunk header: %w", errMalformedResource)
+	}
+	typeID := uint32(c.data[8])
+	flags := c.data[9]
+	entriesStart := int(c.u32(16))
+
+	// the entry index holds a u32 per entry, or a u16 with offset16
+	indexSize := 4
+	if flags&resTypeFlagSparse == 0 && flags&resTypeFlagOffset16 != 0 {
+		indexSize = 2
+	}
+	entryCount := uint64(c.u32(12))
+	if limit := uint64((len(c.data) - c.headerSize) / indexSize); entryCount > limit {
+		entryCount = limit
+	}
+
+	isDefault := true
+	for off := 24; off < 20+int(configSize) && off < c.headerSize; off++ {
+		if c.data[off] != 0 {
+			isDefault = false
+			break
+		}
+	}
+
+	addEntry := func(index int, offset int) {
+		off := entriesStart + offset
+		if off+8 > len(c.data) {
+			return
+		}
+		entryFlags := c.u16(off + 2)
+		var v resValue
+		switch {
+		case entryFlags&resEntryFlagCompact != 0:
+			v = resValue{dataType: uint8(entryFlags >> 8), data: c.u32(off + 4)}
+		case entryFlags&resEntryFlagComplex != 0:
+			return
+		default:
+			valueOff := off + int(c.u16(off))
+			if valueOff+8 > len(c.data) {
+				return
+			}
+			v = resValue{dataType: c.data[valueOff+3], data: c.u32(valueOff + 4)}
+		}
+		id := pkgID<<24 | typeID<<16 | uint32(index)
+		if _, seen := t.values[id]; !seen || isDefault {
+			t.values[id] = v
+		}
+	}
+
+	for i := 0; i < int(entryCount); i++ {
+		switch {
+		case flags&resTypeFlagSparse != 0:
+			off := c.headerSize + i*4
+			addEntry(int(c.u16(off)), int(c.u16(off+2))*4)
+		case flags&resTypeFlagOffset16 != 0:
+			if o := c.u16(c.headerSize + i*2); o != 0xffff {
+				addEntry(i, int(o)*4)
+			}
+		default:
+			if o := c.u32(c.headerSize + i*4); o != 0xffffffff {
+				addEntry(i, int(o))
+			}
+		}
+	}
+	return nil
+}
+
+// resolve follows references until it reaches a plain value.
+func (t *resTable) resolve(v resValue) resValue {
+	for depth := 0; t != nil && v.dataType == resValueReference && depth < 8; depth++ {
+		next, ok := t.values[v.data]
+		if !ok {
+			break
+		}
+		v = next
+	}
+	return v
+}
+
+// attrString renders an attribute value as text, resolving references
+// through t, which may be nil.
+func (t *resTable) attrString(a xmlAttr) string {
+	if a.Raw != "" {
+		return a.Raw
+	}
+	v := t.resolve(a.Value)
+	switch v.dataType {
+	case resValueString:
+		if t == nil {
+			return ""
+		}
+		return t.strings.get(v.data)
+	case resValueIntDec:
+		return strconv.FormatInt(int64(int32(v.data)), 10)
+	case resValueIntHex:
+		return "0x" + strconv.FormatUint(uint64(v.data), 16)
+	case resValueBoolean:
+		return strconv.FormatBool(v.data != 0)
+	case resValueReference:
+		return fmt.Sprintf("@0x%08x", v.data)
+	}
+	return ""
+}
+
+// attrInt returns an attribute's integer value, resolving references
+// through t, which may be nil.
+func (t *resTable) attrInt(a xmlAttr) (int64, bool) {
+	v := t.resolve(a.Value)
+	switch v.dataType {
+	case resValueIntDec, resValueIntHex, resValueBoolean:
+		return int64(int32(v.data)), true
+	}
+	if n, err := strconv.ParseInt(a.Raw, 10, 64); err == nil {
+		return n, true
+	}
+	return 0, false
+}
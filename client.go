@@ -100,7 +100,7 @@ func (c Client) DeviceList() (devices []Device, err error) {
 			key, val := split[0], split[1]
 			mapAttrs[key] = val
 		}
-		devices = append(devices, Device{adbClient: c, serial: fields[0], attrs: mapAttrs})
+		devices = append(devices, Device{adbClient: c, serial: fields[0], attrs: mapAttrs, props: &propCache{}})
 	}
 
 	return
@@ -0,0 +1,80 @@
+package gadb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// CmdResult holds the outcome of a command run by Device.Cmd.
+type CmdResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Cmd calls a binder service the way the device's `cmd` tool does, e.g.
+// Cmd("package", "list", "packages") or Cmd("settings", "get", "global", "adb_enabled").
+//
+// On devices with the abb feature the call goes straight to adbd's binder
+// bridge (abb:, the shell-protocol sibling of abb_exec: which also reports
+// stderr and the exit code) without spawning a process. Otherwise it runs
+// `cmd` through the shell protocol or, on devices without shell_v2, through
+// exec:, in which case stderr is merged into Stdout and ExitCode is always 0.
+//
+// A non-zero exit code is not an error; err reports transport failures only.
+func (d Device) Cmd(service string, args ...string) (result CmdResult, err error) {
+	if strings.TrimSpace(service) == "" {
+		return CmdResult{}, errors.New("adb cmd: service cannot be empty")
+	}
+	var features map[string]bool
+	if features, err = d.featureSet(); err != nil {
+		return CmdResult{}, err
+	}
+
+	adbService, shellProtocol := cmdService(features, append([]string{service}, args...))
+	if shellProtocol {
+		return d.runShellProtocol(adbService)
+	}
+	result.Stdout, err = d.ExecOut(strings.TrimPrefix(adbService, "exec:"))
+	return
+}
+
+// cmdService returns the adb service running the cmd call argv, and
+// whether it speaks the shell protocol. abb: is preferred to abb_exec:,
+// which runs the same binder call but returns only a raw stdout stream.
+func cmdService(features map[string]bool, argv []string) (service string, shellProtocol bool) {
+	switch {
+	case features["abb"]:
+		return "abb:" + strings.Join(argv, "\x00"), true
+	case features["shell_v2"]:
+		return "shell,v2,raw:cmd " + ShellJoin(argv...), true
+	default:
+		return "exec:cmd " + ShellJoin(argv...), false
+	}
+}
+
+// runShellProtocol runs service, which must speak the shell protocol, to
+// completion and collects its output and exit code.
+func (d Device) runShellProtocol(service string) (result CmdResult, err error) {
+	var session *Session
+	if session, err = d.NewSession(); err != nil {
+		return CmdResult{}, err
+	}
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err = session.start(service); err != nil {
+		_ = session.Close()
+		return CmdResult{}, err
+	}
+	err = session.Wait()
+
+	result.Stdout, result.Stderr = stdout.Bytes(), stderr.Bytes()
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitStatus()
+		err = nil
+	}
+	return
+}
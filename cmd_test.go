@@ -0,0 +1,27 @@
+package gadb
+
+import "testing"
+
+func Test_cmdService(t *testing.T) {
+	argv := []string{"settings", "put", "global", "name", "a b"}
+	tests := []struct {
+		features      map[string]bool
+		service       string
+		shellProtocol bool
+	}{
+		{map[string]bool{"abb": true, "abb_exec": true, "shell_v2": true},
+			"abb:settings\x00put\x00global\x00name\x00a b", true},
+		{map[string]bool{"abb_exec": true, "shell_v2": true},
+			"shell,v2,raw:cmd settings put global name 'a b'", true},
+		{map[string]bool{"cmd": true},
+			"exec:cmd settings put global name 'a b'", false},
+		{map[string]bool{},
+			"exec:cmd settings put global name 'a b'", false},
+	}
+	for _, tt := range tests {
+		service, shellProtocol := cmdService(tt.features, argv)
+		if service != tt.service || shellProtocol != tt.shellProtocol {
+			t.Errorf("features %v: got %q, %v, want %q, %v", tt.features, service, shellProtocol, tt.service, tt.shellProtocol)
+		}
+	}
+}
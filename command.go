@@ -0,0 +1,141 @@
+package gadb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Cmd represents a command being prepared or run on the device.
+// It mirrors exec.Cmd: every argument is quoted for the device shell,
+// so arguments may safely contain spaces, quotes and other shell
+// metacharacters.
+type Cmd struct {
+	// Path is the command to run.
+	Path string
+
+	// Args holds the command line arguments, including the command as Args[0].
+	// If Args is empty, Run uses {Path}.
+	Args []string
+
+	// Env specifies additional environment variables for the command,
+	// each of the form "KEY=value".
+	Env []string
+
+	// Dir specifies the working directory of the command on the device.
+	// If Dir is empty, the command runs in the shell's default directory.
+	Dir string
+
+	// Stdin, Stdout and Stderr behave like their Session counterparts.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	device  Device
+	session *Session
+}
+
+// Command returns the Cmd struct to execute the named program on the device
+// with the given arguments.
+func (d Device) Command(name string, args ...string) *Cmd {
+	return &Cmd{
+		Path:   name,
+		Args:   append([]string{name}, args...),
+		device: d,
+	}
+}
+
+// String returns the command line sent to the device shell.
+func (c *Cmd) String() string {
+	line, _ := c.commandLine()
+	return line
+}
+
+func (c *Cmd) commandLine() (string, error) {
+	args := c.Args
+	if len(args) == 0 {
+		args = []string{c.Path}
+	}
+	line := ShellJoin(append([]string{c.Path}, args[1:]...)...)
+	env, err := shellEnvPrefix(c.Env)
+	if err != nil {
+		return line, err
+	}
+	line = env + line
+	if c.Dir != "" {
+		line = "cd " + ShellQuote(c.Dir) + " && " + line
+	}
+	return line, nil
+}
+
+// Start starts the command but does not wait for it to complete.
+func (c *Cmd) Start() error {
+	if c.session != nil {
+		return errors.New("gadb: Cmd already started")
+	}
+	if c.Path == "" {
+		return errors.New("gadb: Cmd has empty Path")
+	}
+	line, err := c.commandLine()
+	if err != nil {
+		return err
+	}
+
+	session, err := c.device.NewSession()
+	if err != nil {
+		return err
+	}
+	session.Stdin = c.Stdin
+	session.Stdout = c.Stdout
+	session.Stderr = c.Stderr
+	if err = session.Start(line); err != nil {
+		_ = session.Close()
+		return err
+	}
+	c.session = session
+	return nil
+}
+
+// Wait waits for the command to exit. A non-zero exit status is reported
+// as an *ExitError.
+func (c *Cmd) Wait() error {
+	if c.session == nil {
+		return errors.New("gadb: Cmd not started")
+	}
+	return c.session.Wait()
+}
+
+// Run starts the command and waits for it to complete.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("gadb: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+	err := c.Run()
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard output
+// and standard error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("gadb: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("gadb: Stderr already set")
+	}
+	var output bytes.Buffer
+	c.Stdout = &output
+	c.Stderr = &output
+	err := c.Run()
+	return output.Bytes(), err
+}
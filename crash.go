@@ -0,0 +1,293 @@
+package gadb
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CrashKind classifies a CrashReport.
+type CrashKind string
+
+const (
+	CrashJava   CrashKind = "java"
+	CrashNative CrashKind = "native"
+	CrashANR    CrashKind = "anr"
+)
+
+// CrashReport describes an application crash or ANR assembled from the log.
+type CrashReport struct {
+	Kind CrashKind
+	// Package is the process name, which is the package name for the main
+	// process of an app.
+	Package string
+	PID     int
+	// Time is when the first line of the report was logged.
+	Time time.Time
+	// Stack holds the full multi-line report as logged.
+	Stack string
+
+	// TombstonePath is the tombstone file written for a native crash, if
+	// it was logged.
+	TombstonePath string
+	// Tombstone holds the tombstone contents when CrashWatchOptions.PullTombstones
+	// is set and the file could be read.
+	Tombstone []byte
+}
+
+// CrashWatchOptions configures WatchCrashes.
+type CrashWatchOptions struct {
+	// PullTombstones fetches the tombstone of each native crash. Reading
+	// /data/tombstones usually requires a userdebug build or root.
+	PullTombstones bool
+}
+
+// CrashStream delivers crash reports.
+type CrashStream struct {
+	*streamState
+	reports chan CrashReport
+}
+
+// Reports returns the channel of crash reports. It is closed when the
+// stream ends, after which Err reports why.
+func (s *CrashStream) Reports() <-chan CrashReport {
+	return s.reports
+}
+
+// crashQuietPeriod is how long a report may go without a new line before
+// it is considered complete.
+var crashQuietPeriod = 500 * time.Millisecond
+
+// WatchCrashes follows the log from now on and reports Java crashes
+// (FATAL EXCEPTION), native crashes (tombstone banners) and ANRs.
+// Besides the crash and main buffers it reads the system buffer, where
+// ActivityManager reports ANRs.
+func (d Device) WatchCrashes(ctx context.Context, opts ...CrashWatchOptions) (*CrashStream, error) {
+	if len(opts) == 0 {
+		opts = []CrashWatchOptions{{}}
+	}
+	entries, err := d.LogcatEntries(ctx, LogcatOptions{
+		Buffers: []LogBuffer{LogBufferCrash, LogBufferMain, LogBufferSystem},
+		Tail:    1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &CrashStream{streamState: newStreamState(ctx), reports: make(chan CrashReport)}
+	s.closeOnDone(entries)
+	go func() {
+		defer close(s.reports)
+		ticker := time.NewTicker(crashQuietPeriod / 2)
+		defer ticker.Stop()
+
+		var det crashDetector
+		for {
+			var reports []CrashReport
+			select {
+			case entry, ok := <-entries.Entries():
+				if !ok {
+					s.emit(d, opts[0], det.flush())
+					s.finish(entries.Err())
+					return
+				}
+				reports = det.feed(entry, time.Now())
+			case now := <-ticker.C:
+				reports = det.expire(now.Add(-crashQuietPeriod))
+			}
+			if !s.emit(d, opts[0], reports) {
+				s.finish(nil)
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (s *CrashStream) emit(d Device, opts CrashWatchOptions, reports []CrashReport) bool {
+	for _, report := range reports {
+		if opts.PullTombstones && report.TombstonePath != "" {
+			var buf bytes.Buffer
+			if err := d.Pull(report.TombstonePath, &buf); err == nil {
+				report.Tombstone = buf.Bytes()
+			} else {
+				debugLog("pull tombstone: " + err.Error())
+			}
+		}
+		select {
+		case s.reports <- report:
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	javaCrashProcess = regexp.MustCompile(`(?m)^Process: (\S+), PID: (\d+)`)
+	nativeCrashPID   = regexp.MustCompile(`(?m)^pid: (\d+), tid: \d+, name: .*>>> (\S+) <<<`)
+	anrProcess       = regexp.MustCompile(`^ANR in (\S+)`)
+	anrPID           = regexp.MustCompile(`(?m)^PID: (\d+)`)
+)
+
+const (
+	nativeCrashBanner = "*** *** *** *** *** *** *** *** *** *** *** *** *** *** *** ***"
+	tombstoneWritten  = "Tombstone written to: "
+)
+
+// pendingCrash is a report still collecting lines.
+type pendingCrash struct {
+	report CrashReport
+	// loggerPID is the process writing the report: the app itself for Java
+	// crashes, crash_dump for native ones and system_server for ANRs.
+	loggerPID int
+	tag       string
+	lastSeen  time.Time
+}
+
+// crashDetector assembles crash reports from log entries. A report is
+// complete once its logger moves on to another tag, a new report starts,
+// or no line has been added for a while.
+type crashDetector struct {
+	pending []*pendingCrash
+}
+
+// feed adds entry, received at now, and returns any reports it completed.
+func (det *crashDetector) feed(entry LogEntry, now time.Time) (done []CrashReport) {
+	kind, starts := crashStart(entry)
+
+	if strings.Contains(entry.Message, tombstoneWritten) {
+		if i := det.tombstoneOwner(entry); i >= 0 {
+			p := det.pending[i]
+			if p.loggerPID == entry.PID && p.tag == entry.Tag {
+				p.report.Stack += "\n" + entry.Message
+			}
+			det.collectDetails(p, entry.Message)
+			return append(done, det.remove(i))
+		}
+	}
+
+	for i := 0; i < len(det.pending); i++ {
+		p := det.pending[i]
+		if p.loggerPID != entry.PID {
+			continue
+		}
+		if !starts && entry.Tag == p.tag {
+			p.report.Stack += "\n" + entry.Message
+			p.lastSeen = now
+			det.collectDetails(p, entry.Message)
+			return
+		}
+		// the logger moved on: the report is complete
+		done = append(done, det.remove(i))
+		i--
+	}
+
+	if starts {
+		p := &pendingCrash{
+			report:    CrashReport{Kind: kind, Time: entry.Time, Stack: entry.Message},
+			loggerPID: entry.PID,
+			tag:       entry.Tag,
+			lastSeen:  now,
+		}
+		if kind == CrashJava {
+			p.report.PID = entry.PID
+		}
+		det.collectDetails(p, entry.Message)
+		det.pending = append(det.pending, p)
+	}
+	return
+}
+
+// tombstoneOwner returns the index of the pending native crash a
+// "Tombstone written to" line belongs to, or -1. Before Android 8 the
+// line comes from the DEBUG logger of the report; since then tombstoned
+// logs it from its own process, without naming the crashed one, so it is
+// matched by the crashed pid when it appears in the line and otherwise
+// goes to the oldest native crash still waiting for its tombstone.
+func (det *crashDetector) tombstoneOwner(entry LogEntry) int {
+	owner := -1
+	for i, p := range det.pending {
+		if p.report.Kind != CrashNative || p.report.TombstonePath != "" {
+			continue
+		}
+		if p.loggerPID == entry.PID && p.tag == entry.Tag {
+			return i
+		}
+		if p.report.PID != 0 && strings.Contains(entry.Message, "pid "+strconv.Itoa(p.report.PID)) {
+			return i
+		}
+		if owner < 0 {
+			owner = i
+		}
+	}
+	return owner
+}
+
+// expire returns the reports that have not grown since before.
+func (det *crashDetector) expire(before time.Time) (done []CrashReport) {
+	for i := 0; i < len(det.pending); i++ {
+		if det.pending[i].lastSeen.Before(before) {
+			done = append(done, det.remove(i))
+			i--
+		}
+	}
+	return
+}
+
+// flush returns every pending report.
+func (det *crashDetector) flush() (done []CrashReport) {
+	for _, p := range det.pending {
+		done = append(done, p.report)
+	}
+	det.pending = nil
+	return
+}
+
+func (det *crashDetector) remove(i int) CrashReport {
+	p := det.pending[i]
+	det.pending = append(det.pending[:i], det.pending[i+1:]...)
+	return p.report
+}
+
+func (det *crashDetector) collectDetails(p *pendingCrash, message string) {
+	r := &p.report
+	switch r.Kind {
+	case CrashJava:
+		if m := javaCrashProcess.FindStringSubmatch(message); m != nil {
+			r.Package = m[1]
+			r.PID, _ = strconv.Atoi(m[2])
+		}
+	case CrashNative:
+		if m := nativeCrashPID.FindStringSubmatch(message); m != nil {
+			r.PID, _ = strconv.Atoi(m[1])
+			r.Package = m[2]
+		}
+		if i := strings.Index(message, tombstoneWritten); i >= 0 {
+			r.TombstonePath = strings.TrimSpace(message[i+len(tombstoneWritten):])
+		}
+	case CrashANR:
+		if m := anrProcess.FindStringSubmatch(message); m != nil {
+			r.Package = m[1]
+		}
+		if m := anrPID.FindStringSubmatch(message); m != nil && r.PID == 0 {
+			r.PID, _ = strconv.Atoi(m[1])
+		}
+	}
+}
+
+// crashStart reports whether entry is the first line of a crash report.
+func crashStart(entry LogEntry) (CrashKind, bool) {
+	switch {
+	case entry.Tag == "AndroidRuntime" && strings.HasPrefix(entry.Message, "FATAL EXCEPTION"):
+		return CrashJava, true
+	case entry.Tag == "DEBUG" && strings.HasPrefix(entry.Message, nativeCrashBanner):
+		return CrashNative, true
+	case entry.Tag == "ActivityManager" && strings.HasPrefix(entry.Message, "ANR in "):
+		return CrashANR, true
+	}
+	return "", false
+}
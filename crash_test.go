@@ -0,0 +1,83 @@
+package gadb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashDetector(t *testing.T) {
+	now := time.Now()
+	entries := []LogEntry{
+		{PID: 4321, Tag: "AndroidRuntime", Priority: LogPriorityError,
+			Message: "FATAL EXCEPTION: main\nProcess: com.example, PID: 4321\njava.lang.IllegalStateException: boom"},
+		{PID: 999, Tag: "DEBUG", Priority: LogPriorityFatal, Message: nativeCrashBanner},
+		{PID: 4321, Tag: "AndroidRuntime", Priority: LogPriorityError,
+			Message: "\tat com.example.Main.onCreate(Main.java:42)"},
+		{PID: 999, Tag: "DEBUG", Priority: LogPriorityFatal,
+			Message: "pid: 5555, tid: 5560, name: RenderThread  >>> com.example.native <<<"},
+		{PID: 999, Tag: "DEBUG", Priority: LogPriorityFatal, Message: "signal 11 (SIGSEGV)"},
+		{PID: 4321, Tag: "Process", Priority: LogPriorityInfo, Message: "Sending signal. PID: 4321 SIG: 9"},
+		{PID: 999, Tag: "DEBUG", Priority: LogPriorityError,
+			Message: "Tombstone written to: /data/tombstones/tombstone_03"},
+		{PID: 1500, Tag: "ActivityManager", Priority: LogPriorityError,
+			Message: "ANR in com.example.slow (com.example.slow/.Main)\nPID: 6000\nReason: Input dispatching timed out"},
+	}
+
+	var det crashDetector
+	var reports []CrashReport
+	for _, e := range entries {
+		reports = append(reports, det.feed(e, now)...)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports before expiry, want 2: %+v", len(reports), reports)
+	}
+	reports = append(reports, det.expire(now.Add(time.Second))...)
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want 3: %+v", len(reports), reports)
+	}
+
+	java, native, anr := reports[0], reports[1], reports[2]
+	if java.Kind != CrashJava || java.Package != "com.example" || java.PID != 4321 ||
+		java.Stack != entries[0].Message+"\n"+entries[2].Message {
+		t.Errorf("java report: %+v", java)
+	}
+	if native.Kind != CrashNative || native.Package != "com.example.native" || native.PID != 5555 ||
+		native.TombstonePath != "/data/tombstones/tombstone_03" {
+		t.Errorf("native report: %+v", native)
+	}
+	if anr.Kind != CrashANR || anr.Package != "com.example.slow" || anr.PID != 6000 {
+		t.Errorf("anr report: %+v", anr)
+	}
+}
+
+func TestCrashDetector_tombstoned(t *testing.T) {
+	now := time.Now()
+	entries := []LogEntry{
+		{PID: 999, Tag: "DEBUG", Priority: LogPriorityFatal, Message: nativeCrashBanner},
+		{PID: 999, Tag: "DEBUG", Priority: LogPriorityFatal,
+			Message: "pid: 5555, tid: 5560, name: RenderThread  >>> com.example.native <<<"},
+		{PID: 999, Tag: "DEBUG", Priority: LogPriorityFatal, Message: "signal 11 (SIGSEGV)"},
+		// since Android 8, tombstoned logs the path from its own process
+		{PID: 612, Tag: "tombstoned", Priority: LogPriorityError,
+			Message: "Tombstone written to: /data/tombstones/tombstone_07"},
+	}
+
+	var det crashDetector
+	var reports []CrashReport
+	for _, e := range entries {
+		reports = append(reports, det.feed(e, now)...)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports before expiry, want 1: %+v", len(reports), reports)
+	}
+	native := reports[0]
+	if native.Kind != CrashNative || native.PID != 5555 || native.TombstonePath != "/data/tombstones/tombstone_07" {
+		t.Errorf("native report: %+v", native)
+	}
+	if want := nativeCrashBanner + "\n" + entries[1].Message + "\n" + entries[2].Message; native.Stack != want {
+		t.Errorf("native stack = %q, want %q", native.Stack, want)
+	}
+	if len(det.pending) != 0 {
+		t.Errorf("%d reports still pending", len(det.pending))
+	}
+}
@@ -1,7 +1,6 @@
 package gadb
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -58,6 +57,7 @@ type Device struct {
 	adbClient Client
 	serial    string
 	attrs     map[string]string
+	props     *propCache
 }
 
 func (d Device) HasAttribute(key string) bool {
@@ -111,6 +111,39 @@ func (d Device) IsUsb() (bool, error) {
 	return usb != "", nil
 }
 
+// Features returns the adb features supported by both the device and the
+// adb server, such as "shell_v2", "cmd" or "abb_exec".
+func (d Device) Features() (features []string, err error) {
+	var resp string
+	if resp, err = d.adbClient.executeCommand(fmt.Sprintf("host-serial:%s:features", d.serial)); err != nil {
+		return nil, err
+	}
+	for _, f := range strings.Split(strings.TrimSpace(resp), ",") {
+		if f != "" {
+			features = append(features, f)
+		}
+	}
+	return
+}
+
+// HasFeature reports whether feature is listed in Features.
+func (d Device) HasFeature(feature string) (bool, error) {
+	features, err := d.featureSet()
+	return features[feature], err
+}
+
+func (d Device) featureSet() (map[string]bool, error) {
+	features, err := d.Features()
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(features))
+	for _, f := range features {
+		set[f] = true
+	}
+	return set, nil
+}
+
 func (d Device) State() (DeviceState, error) {
 	resp, err := d.adbClient.executeCommand(fmt.Sprintf("host-serial:%s:get-state", d.serial))
 	return deviceStateConv(resp), err
@@ -158,6 +191,9 @@ func (d Device) ForwardKill(localPort int) (err error) {
 	return
 }
 
+// RunShellCommand runs cmd through the device shell. args are appended
+// verbatim, so they may carry shell syntax such as pipes; use Command when
+// arguments must be passed literally.
 func (d Device) RunShellCommand(cmd string, args ...string) (string, error) {
 	raw, err := d.RunShellCommandWithBytes(cmd, args...)
 	return string(raw), err
@@ -222,6 +258,23 @@ func (d Device) executeCommand(command string, onlyVerifyResponse ...bool) (raw
 	return
 }
 
+// openService connects to service on the device and verifies the response,
+// leaving the connection open for the caller to stream over.
+func (d Device) openService(service string) (tp transport, err error) {
+	if tp, err = d.createDeviceTransport(); err != nil {
+		return transport{}, err
+	}
+	if err = tp.Send(service); err == nil {
+		err = tp.VerifyResponse()
+	}
+	if err != nil {
+		_ = tp.Close()
+		return transport{}, err
+	}
+	_ = tp.sock.SetReadDeadline(time.Time{})
+	return
+}
+
 func (d Device) List(remotePath string) (devFileInfos []DeviceFileInfo, err error) {
 	var tp transport
 	if tp, err = d.createDeviceTransport(); err != nil {
@@ -320,41 +373,3 @@ func (d Device) Pull(remotePath string, dest io.Writer) (err error) {
 	err = sync.WriteStream(dest)
 	return
 }
-
-func (d Device) Logcat(dst io.Writer, exitChan chan bool) error {
-	var tp transport
-	var err error
-	if tp, err = d.createDeviceTransport(); err != nil {
-		return err
-	}
-	defer func() { _ = tp.Close() }()
-
-	if err = tp.Send("shell:logcat"); err != nil {
-		return err
-	}
-	if err = tp.VerifyResponse(); err != nil {
-		return err
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		r := NewReader(ctx, tp.sock)
-		io.Copy(dst, r)
-	}()
-	<-exitChan
-	cancel()
-	return err
-}
-
-func (d Device) Logcat2File(file string, exitChan chan bool) error {
-	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_SYNC|os.O_APPEND, 0755)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	return d.Logcat(f, exitChan)
-}
-
-func (d Device) LogcatClear() error {
-	_, err := d.executeCommand("shell:logcat -c")
-	return err
-}
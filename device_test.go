@@ -64,8 +64,8 @@ func TestDevice_Product(t *testing.T) {
 
 	for i := range devices {
 		dev := devices[i]
-		product := dev.Product()
-		t.Log(dev.Serial(), product)
+		product, err := dev.Product()
+		t.Log(dev.Serial(), product, err)
 	}
 }
 
@@ -82,7 +82,8 @@ func TestDevice_Model(t *testing.T) {
 
 	for i := range devices {
 		dev := devices[i]
-		t.Log(dev.Serial(), dev.Model())
+		model, err := dev.Model()
+		t.Log(dev.Serial(), model, err)
 	}
 }
 
@@ -99,7 +100,9 @@ func TestDevice_Usb(t *testing.T) {
 
 	for i := range devices {
 		dev := devices[i]
-		t.Log(dev.Serial(), dev.Usb(), dev.IsUsb())
+		usb, err := dev.Usb()
+		isUsb, _ := dev.IsUsb()
+		t.Log(dev.Serial(), usb, isUsb, err)
 	}
 
 }
@@ -0,0 +1,284 @@
+package gadb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventLogTagsPath is where devices keep the map of event log tag IDs.
+const EventLogTagsPath = "/system/etc/event-log-tags"
+
+// EventTagField describes one field of an event log tag.
+type EventTagField struct {
+	Name string
+	// Type is the declared type: 1 int, 2 long, 3 string, 4 list, 5 float.
+	Type int
+	// Unit is the declared unit code, or 0 if none was given.
+	Unit int
+}
+
+// EventTag describes a tag declared in the event-log-tags file.
+type EventTag struct {
+	ID     uint32
+	Name   string
+	Fields []EventTagField
+}
+
+// EventLogTags maps tag IDs to their declarations.
+type EventLogTags map[uint32]EventTag
+
+var eventTagField = regexp.MustCompile(`\(([^|()]*)\|(\d+)(?:\|(\d+))?\)`)
+
+// ParseEventLogTags parses the event-log-tags file format, e.g.
+//
+//	30015 am_proc_start (User|1|5),(PID|1|5),(UID|1|5),(Process Name|3),(Type|3),(Component|3)
+func ParseEventLogTags(r io.Reader) (EventLogTags, error) {
+	tags := EventLogTags{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			debugLog(fmt.Sprintf("can't parse event tag: %s", line))
+			continue
+		}
+		tag := EventTag{ID: uint32(id), Name: fields[1]}
+		for _, m := range eventTagField.FindAllStringSubmatch(line, -1) {
+			field := EventTagField{Name: m[1]}
+			field.Type, _ = strconv.Atoi(m[2])
+			if m[3] != "" {
+				field.Unit, _ = strconv.Atoi(m[3])
+			}
+			tag.Fields = append(tag.Fields, field)
+		}
+		tags[tag.ID] = tag
+	}
+	return tags, scanner.Err()
+}
+
+// EventLogTags pulls and parses the device's event-log-tags file.
+func (d Device) EventLogTags() (EventLogTags, error) {
+	var buf bytes.Buffer
+	if err := d.Pull(EventLogTagsPath, &buf); err != nil {
+		return nil, fmt.Errorf("pull %s: %w", EventLogTagsPath, err)
+	}
+	return ParseEventLogTags(&buf)
+}
+
+// Event is a decoded entry of the events log buffer.
+type Event struct {
+	Time time.Time
+	PID  int
+	TID  int
+	UID  int
+
+	TagID uint32
+	// Tag is the tag name, e.g. "am_proc_start", or empty if the tag is not
+	// declared in the tags map.
+	Tag string
+	// Values holds the decoded payload. A list payload is flattened into
+	// its elements; each value is an int32, int64, float32, string or, for
+	// nested lists, []interface{}.
+	Values []interface{}
+
+	fields []EventTagField
+}
+
+// Field returns the value of the named field as declared in event-log-tags.
+func (e Event) Field(name string) (interface{}, bool) {
+	for i, f := range e.fields {
+		if f.Name == name && i < len(e.Values) {
+			return e.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+// Event payload value types.
+const (
+	eventTypeInt    = 0
+	eventTypeLong   = 1
+	eventTypeString = 2
+	eventTypeList   = 3
+	eventTypeFloat  = 4
+)
+
+var errShortEvent = errors.New("event payload truncated")
+
+// DecodeEvent decodes the binary payload of an events buffer entry.
+func DecodeEvent(entry LogEntry, tags EventLogTags) (Event, error) {
+	if len(entry.Payload) < 4 {
+		return Event{}, errShortEvent
+	}
+	ev := Event{
+		Time:  entry.Time,
+		PID:   entry.PID,
+		TID:   entry.TID,
+		UID:   entry.UID,
+		TagID: binary.LittleEndian.Uint32(entry.Payload),
+	}
+	if tag, ok := tags[ev.TagID]; ok {
+		ev.Tag, ev.fields = tag.Name, tag.Fields
+	}
+
+	payload := entry.Payload[4:]
+	if len(payload) == 0 {
+		return ev, nil
+	}
+	value, _, err := decodeEventValue(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("decode event %d: %w", ev.TagID, err)
+	}
+	if list, ok := value.([]interface{}); ok {
+		ev.Values = list
+	} else {
+		ev.Values = []interface{}{value}
+	}
+	return ev, nil
+}
+
+// decodeEventValue decodes one typed value and returns the remaining bytes.
+func decodeEventValue(b []byte) (value interface{}, rest []byte, err error) {
+	if len(b) < 1 {
+		return nil, nil, errShortEvent
+	}
+	typ, b := b[0], b[1:]
+	switch typ {
+	case eventTypeInt:
+		if len(b) < 4 {
+			return nil, nil, errShortEvent
+		}
+		return int32(binary.LittleEndian.Uint32(b)), b[4:], nil
+	case eventTypeLong:
+		if len(b) < 8 {
+			return nil, nil, errShortEvent
+		}
+		return int64(binary.LittleEndian.Uint64(b)), b[8:], nil
+	case eventTypeFloat:
+		if len(b) < 4 {
+			return nil, nil, errShortEvent
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), b[4:], nil
+	case eventTypeString:
+		if len(b) < 4 {
+			return nil, nil, errShortEvent
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		if len(b)-4 < n {
+			return nil, nil, errShortEvent
+		}
+		return string(b[4 : 4+n]), b[4+n:], nil
+	case eventTypeList:
+		if len(b) < 1 {
+			return nil, nil, errShortEvent
+		}
+		count := int(b[0])
+		b = b[1:]
+		list := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			var item interface{}
+			if item, b, err = decodeEventValue(b); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, b, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown event value type %d", typ)
+	}
+}
+
+// EventStream delivers decoded events.
+type EventStream struct {
+	*streamState
+	events chan Event
+}
+
+// Events returns the channel of events. It is closed when the stream ends,
+// after which Err reports why.
+func (s *EventStream) Events() <-chan Event {
+	return s.events
+}
+
+// Events follows the events log buffer and decodes each entry using the
+// device's event-log-tags. opts may narrow the entries, e.g. with Filters
+// naming tags such as "am_crash"; Buffers defaults to the events buffer and
+// Format is ignored. As logcat does not apply filters to binary output,
+// Filters are matched against the decoded tags, taking events to be logged
+// at info priority.
+func (d Device) Events(ctx context.Context, opts LogcatOptions) (*EventStream, error) {
+	tags, err := d.EventLogTags()
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Buffers) == 0 {
+		opts.Buffers = []LogBuffer{LogBufferEvents}
+	}
+	opts.Format = LogcatFormatBinary
+	filters := opts.Filters
+	opts.Filters = nil
+
+	entries, err := d.LogcatEntries(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &EventStream{streamState: newStreamState(ctx), events: make(chan Event)}
+	s.closeOnDone(entries)
+	go func() {
+		defer close(s.events)
+		for entry := range entries.Entries() {
+			if entry.Payload == nil {
+				continue
+			}
+			ev, err := DecodeEvent(entry, tags)
+			if err != nil {
+				debugLog(err.Error())
+				continue
+			}
+			if !eventWanted(filters, ev.Tag) {
+				continue
+			}
+			select {
+			case s.events <- ev:
+			case <-s.ctx.Done():
+				s.finish(nil)
+				return
+			}
+		}
+		s.finish(entries.Err())
+	}()
+	return s, nil
+}
+
+// eventWanted reports whether filters keep an event with the given tag,
+// like logcat does for an info entry: a filter naming the tag takes
+// precedence over one for "*", and the last of either kind wins.
+func eventWanted(filters []LogFilter, tag string) bool {
+	threshold, named := LogPriorityDefault, false
+	for _, f := range filters {
+		switch {
+		case f.Tag == tag:
+			threshold, named = f.Priority, true
+		case f.Tag == "*" && !named:
+			threshold = f.Priority
+		}
+	}
+	return LogPriorityInfo >= threshold
+}
@@ -0,0 +1,84 @@
+package gadb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testEventLogTags = `# comment
+42 answer (to life the universe etc|3)
+30015 am_proc_start (User|1|5),(PID|1|5),(UID|1|5),(Process Name|3),(Type|3),(Component|3)
+2722 battery_level (level|1|6),(voltage|1|1),(temperature|1|1)
+`
+
+func TestParseEventLogTags(t *testing.T) {
+	tags, err := ParseEventLogTags(strings.NewReader(testEventLogTags))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := EventTag{ID: 30015, Name: "am_proc_start", Fields: []EventTagField{
+		{"User", 1, 5}, {"PID", 1, 5}, {"UID", 1, 5},
+		{"Process Name", 3, 0}, {"Type", 3, 0}, {"Component", 3, 0},
+	}}
+	if !reflect.DeepEqual(tags[30015], want) {
+		t.Errorf("got %+v", tags[30015])
+	}
+	if len(tags) != 3 {
+		t.Errorf("parsed %d tags, want 3", len(tags))
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	tags, _ := ParseEventLogTags(strings.NewReader(testEventLogTags))
+
+	str := func(s string) []byte {
+		return append([]byte{2, byte(len(s)), 0, 0, 0}, s...)
+	}
+	payload := []byte{0x3f, 0x75, 0, 0} // 30015
+	payload = append(payload, 3, 6)     // list of 6
+	payload = append(payload, 0, 0, 0, 0, 0)
+	payload = append(payload, 0, 0xd2, 0x04, 0, 0)
+	payload = append(payload, 0, 0x8b, 0x27, 0, 0)
+	payload = append(payload, str("com.example")...)
+	payload = append(payload, str("activity")...)
+	payload = append(payload, str("{com.example/com.example.Main}")...)
+
+	ev, err := DecodeEvent(LogEntry{PID: 500, Buffer: LogBufferEvents, Payload: payload}, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Tag != "am_proc_start" || len(ev.Values) != 6 {
+		t.Fatalf("got %+v", ev)
+	}
+	if pid, _ := ev.Field("PID"); pid != int32(1234) {
+		t.Errorf("PID = %v", pid)
+	}
+	if name, _ := ev.Field("Process Name"); name != "com.example" {
+		t.Errorf("Process Name = %v", name)
+	}
+
+	if _, err := DecodeEvent(LogEntry{Payload: payload[:len(payload)-3]}, tags); err == nil {
+		t.Error("expected error for truncated payload")
+	}
+}
+
+func Test_eventWanted(t *testing.T) {
+	crashesOnly := []LogFilter{{Tag: "am_crash", Priority: LogPriorityInfo}, {Tag: "*", Priority: LogPrioritySilent}}
+	tests := []struct {
+		filters []LogFilter
+		tag     string
+		want    bool
+	}{
+		{nil, "am_proc_start", true},
+		{crashesOnly, "am_crash", true},
+		{crashesOnly, "am_proc_start", false},
+		{[]LogFilter{{Tag: "am_anr", Priority: LogPriorityWarn}}, "am_anr", false},
+		{[]LogFilter{{Tag: "am_anr", Priority: LogPriorityWarn}}, "am_crash", true},
+	}
+	for _, tt := range tests {
+		if got := eventWanted(tt.filters, tt.tag); got != tt.want {
+			t.Errorf("eventWanted(%v, %q) = %v, want %v", tt.filters, tt.tag, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	"github.com/electricbubble/gadb"
 	"log"
 	"os"
-	"strings"
 )
 
 func main() {
@@ -23,22 +23,15 @@ func main() {
 	userHomeDir, _ := os.UserHomeDir()
 	apk, err := os.Open(userHomeDir + "/Desktop/xuexi_android_10002068.apk")
 	checkErr(err)
+	defer apk.Close()
 
-	log.Println("starting to push apk")
-
-	remotePath := "/data/local/tmp/xuexi_android_10002068.apk"
-	err = dev.PushFile(apk, remotePath)
-	checkErr(err, "adb push")
-
-	log.Println("push completed")
+	stat, err := apk.Stat()
+	checkErr(err)
 
 	log.Println("starting to install apk")
 
-	shellOutput, err := dev.RunShellCommand("pm install", remotePath)
-	checkErr(err, "pm install")
-	if !strings.Contains(shellOutput, "Success") {
-		log.Fatalln("fail to install: ", shellOutput)
-	}
+	err = dev.Install(context.Background(), apk, stat.Size(), gadb.InstallOptions{Replace: true})
+	checkErr(err, "install")
 
 	log.Println("install completed")
 
@@ -0,0 +1,73 @@
+package gadb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ExecOut runs cmd with the exec: service and returns its raw output.
+// Unlike RunShellCommand no PTY is involved, so binary output such as
+// `screencap -p` or `tar c` arrives unmodified.
+func (d Device) ExecOut(cmd string) ([]byte, error) {
+	r, err := d.ExecOutReader(context.Background(), cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return ioutil.ReadAll(r)
+}
+
+// ExecOutReader runs cmd with the exec: service and returns a stream of its
+// raw output. Cancelling ctx terminates the stream; the caller must Close
+// the returned reader.
+func (d Device) ExecOutReader(ctx context.Context, cmd string) (io.ReadCloser, error) {
+	tp, err := d.openExec(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return newCtxReadCloser(ctx, tp.sock), nil
+}
+
+// ExecIn runs cmd with the exec: service, copies stdin to its standard input
+// and returns its raw output. Once stdin is exhausted the write half of the
+// connection is closed, so commands reading until EOF terminate.
+func (d Device) ExecIn(cmd string, stdin io.Reader) ([]byte, error) {
+	tp, err := d.openExec(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tp.Close() }()
+	return execIn(tp, stdin)
+}
+
+func (d Device) openExec(cmd string) (transport, error) {
+	if strings.TrimSpace(cmd) == "" {
+		return transport{}, errors.New("adb exec: command cannot be empty")
+	}
+	return d.openService("exec:" + cmd)
+}
+
+// execIn streams stdin to an opened raw service while collecting its output.
+func execIn(tp transport, stdin io.Reader) ([]byte, error) {
+	copyErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(tp.sock, stdin)
+		if err != nil {
+			err = fmt.Errorf("adb exec: copy stdin: %w", err)
+		}
+		_ = tp.CloseWrite()
+		copyErr <- err
+	}()
+
+	raw, err := ioutil.ReadAll(tp.sock)
+	if err != nil {
+		// unblock the copy if the device went away before consuming stdin
+		_ = tp.Close()
+		return raw, errors.Join(err, <-copyErr)
+	}
+	return raw, <-copyErr
+}
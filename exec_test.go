@@ -0,0 +1,85 @@
+package gadb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	if client, err = net.Dial("tcp", ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return
+}
+
+func Test_execIn(t *testing.T) {
+	client, server := tcpPair(t)
+
+	// behave like `exec:tr a-z A-Z`: echo until EOF, then close
+	go func() {
+		raw, _ := ioutil.ReadAll(server)
+		_, _ = server.Write(bytes.ToUpper(raw))
+		_ = server.Close()
+	}()
+
+	input := strings.Repeat("binary\r\n\x00safe\n", 10000)
+	out, err := execIn(transport{sock: client}, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != strings.ToUpper(input) {
+		t.Errorf("unexpected output of %d bytes", len(out))
+	}
+}
+
+func Test_ctxReadCloser(t *testing.T) {
+	client, _ := tcpPair(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newCtxReadCloser(ctx, client)
+	defer r.Close()
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := io.Copy(ioutil.Discard, r)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNewReader_interruptsBlockedRead(t *testing.T) {
+	client, _ := tcpPair(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := NewReader(ctx, client).Read(make([]byte, 1))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
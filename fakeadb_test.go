@@ -0,0 +1,107 @@
+package gadb
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeADB is an adb server serving a single device, "fake", whose
+// services are answered by handle. Host queries for the device's features
+// and state are answered directly.
+type fakeADB struct {
+	features string
+	// handle serves service, such as "exec:cmd package list packages",
+	// after it has been acknowledged. conn is closed when it returns.
+	handle func(service string, conn net.Conn)
+
+	mu       sync.Mutex
+	services []string
+}
+
+// newFakeADB starts f and returns a device connected to it.
+func newFakeADB(t *testing.T, f *fakeADB) Device {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return Device{adbClient: Client{host: addr.IP.String(), port: addr.Port}, serial: "fake", props: &propCache{}}
+}
+
+func (f *fakeADB) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	req, err := readFakeRequest(conn)
+	if err != nil {
+		return
+	}
+	switch req {
+	case "host-serial:fake:features":
+		writeFakeOkay(conn, f.features)
+		return
+	case "host-serial:fake:get-state":
+		writeFakeOkay(conn, "device")
+		return
+	case "host:transport:fake":
+		_, _ = io.WriteString(conn, "OKAY")
+	default:
+		_, _ = fmt.Fprintf(conn, "FAIL%04x%s", len(req), req)
+		return
+	}
+
+	service, err := readFakeRequest(conn)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	f.services = append(f.services, service)
+	f.mu.Unlock()
+	_, _ = io.WriteString(conn, "OKAY")
+	f.handle(service, conn)
+}
+
+// calls returns the services requested so far.
+func (f *fakeADB) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.services...)
+}
+
+func readFakeRequest(r io.Reader) (string, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(size[:]), 16, 16)
+	if err != nil {
+		return "", err
+	}
+	req := make([]byte, n)
+	_, err = io.ReadFull(r, req)
+	return string(req), err
+}
+
+func writeFakeOkay(w io.Writer, payload string) {
+	_, _ = fmt.Fprintf(w, "OKAY%04x%s", len(payload), payload)
+}
+
+// fakeCommand splits an "exec:cmd <service> args..." request into the
+// words of the command line, which must not need quoting.
+func fakeCommand(service string) []string {
+	return strings.Fields(strings.TrimPrefix(service, "exec:"))
+}
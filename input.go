@@ -0,0 +1,163 @@
+package gadb
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyCode is an Android key code, see android.view.KeyEvent.
+type KeyCode int
+
+const (
+	KeyHome       KeyCode = 3
+	KeyBack       KeyCode = 4
+	KeyDpadUp     KeyCode = 19
+	KeyDpadDown   KeyCode = 20
+	KeyDpadLeft   KeyCode = 21
+	KeyDpadRight  KeyCode = 22
+	KeyDpadCenter KeyCode = 23
+	KeyVolumeUp   KeyCode = 24
+	KeyVolumeDown KeyCode = 25
+	KeyPower      KeyCode = 26
+	KeyTab        KeyCode = 61
+	KeySpace      KeyCode = 62
+	KeyEnter      KeyCode = 66
+	KeyDel        KeyCode = 67
+	KeyMenu       KeyCode = 82
+	KeyEscape     KeyCode = 111
+	KeyAppSwitch  KeyCode = 187
+	KeySleep      KeyCode = 223
+	KeyWakeup     KeyCode = 224
+)
+
+// InputSource is the input device type events are injected as.
+type InputSource string
+
+const (
+	SourceTouchscreen InputSource = "touchscreen"
+	SourceTouchpad    InputSource = "touchpad"
+	SourceMouse       InputSource = "mouse"
+	SourceStylus      InputSource = "stylus"
+	SourceTrackball   InputSource = "trackball"
+	SourceKeyboard    InputSource = "keyboard"
+	SourceDpad        InputSource = "dpad"
+	SourceGamepad     InputSource = "gamepad"
+	SourceJoystick    InputSource = "joystick"
+)
+
+// InputOptions selects where `input` injects events.
+type InputOptions struct {
+	// Source overrides the default source of the command, e.g. mouse
+	// instead of touchscreen for taps.
+	Source InputSource
+	// DisplayID targets a logical display (-d) instead of the default one.
+	DisplayID int
+}
+
+// Tap taps the screen at (x, y).
+func (d Device) Tap(x, y int, opts ...InputOptions) error {
+	return d.input(opts, "tap", strconv.Itoa(x), strconv.Itoa(y))
+}
+
+// Swipe drags from one point to another over duration.
+func (d Device) Swipe(from, to image.Point, duration time.Duration, opts ...InputOptions) error {
+	return d.input(opts, "swipe",
+		strconv.Itoa(from.X), strconv.Itoa(from.Y), strconv.Itoa(to.X), strconv.Itoa(to.Y),
+		strconv.FormatInt(duration.Milliseconds(), 10))
+}
+
+// LongPress touches (x, y) for duration.
+func (d Device) LongPress(x, y int, duration time.Duration, opts ...InputOptions) error {
+	return d.Swipe(image.Pt(x, y), image.Pt(x, y), duration, opts...)
+}
+
+// KeyEvent presses and releases a key; with longpress, the key is held
+// long enough to trigger its long-press action.
+func (d Device) KeyEvent(code KeyCode, longpress bool, opts ...InputOptions) error {
+	args := []string{"keyevent"}
+	if longpress {
+		args = append(args, "--longpress")
+	}
+	return d.input(opts, append(args, strconv.Itoa(int(code)))...)
+}
+
+// Text types s into the focused view. Newlines and tabs are sent as the
+// Enter and Tab keys. Only printable ASCII can be typed this way.
+func (d Device) Text(s string, opts ...InputOptions) error {
+	commands, err := textCommands(s)
+	if err != nil {
+		return err
+	}
+	for _, args := range commands {
+		if err = d.input(opts, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// textCommands splits s into `input` commands typing it.
+func textCommands(s string) ([][]string, error) {
+	var commands [][]string
+	var chunk strings.Builder
+	flush := func() {
+		if chunk.Len() > 0 {
+			commands = append(commands, []string{"text", chunk.String()})
+			chunk.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\n':
+			flush()
+			commands = append(commands, []string{"keyevent", strconv.Itoa(int(KeyEnter))})
+		case c == '\t':
+			flush()
+			commands = append(commands, []string{"keyevent", strconv.Itoa(int(KeyTab))})
+		case c == ' ':
+			// input text reads "%s" as a space
+			chunk.WriteString("%s")
+		case c < ' ' || c > '~':
+			return nil, fmt.Errorf("input text: cannot type %q: only printable ASCII is supported", s)
+		case c == 's' && i > 0 && s[i-1] == '%':
+			// a literal "%s" must not reach input in one piece
+			flush()
+			chunk.WriteByte(c)
+		default:
+			chunk.WriteByte(c)
+		}
+	}
+	flush()
+	return commands, nil
+}
+
+func (opts InputOptions) args() []string {
+	var args []string
+	if opts.Source != "" {
+		args = append(args, string(opts.Source))
+	}
+	if opts.DisplayID != 0 {
+		args = append(args, "-d", strconv.Itoa(opts.DisplayID))
+	}
+	return args
+}
+
+// input runs an `input` command. It prints nothing on success.
+func (d Device) input(opts []InputOptions, args ...string) error {
+	if len(opts) != 0 {
+		args = append(opts[0].args(), args...)
+	}
+	out, err := d.RunShellCommand("input", ShellJoin(args...))
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return errors.New("input: " + out)
+	}
+	return nil
+}
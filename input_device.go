@@ -0,0 +1,445 @@
+package gadb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Linux input event types and codes (linux/input-event-codes.h).
+const (
+	EvSyn = 0x00
+	EvKey = 0x01
+	EvAbs = 0x03
+
+	SynReport = 0x00
+
+	BtnTouch = 0x14a
+
+	AbsMTSlot       = 0x2f
+	AbsMTTouchMajor = 0x30
+	AbsMTPositionX  = 0x35
+	AbsMTPositionY  = 0x36
+	AbsMTTrackingID = 0x39
+	AbsMTPressure   = 0x3a
+)
+
+// InputEvent is a Linux input event.
+type InputEvent struct {
+	// Device is the path of the device node the event came from, when
+	// recorded.
+	Device string
+	// Timestamp is the kernel event time, usually CLOCK_MONOTONIC, when
+	// recorded.
+	Timestamp time.Duration
+
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// AbsInfo describes the range of an absolute axis.
+type AbsInfo struct {
+	Value, Min, Max, Fuzz, Flat, Resolution int32
+}
+
+// InputDevice describes a kernel input device as reported by `getevent -p`.
+type InputDevice struct {
+	// Path is the device node, e.g. "/dev/input/event2".
+	Path string
+	Name string
+	// Events maps each supported event type to its supported codes.
+	Events map[uint16][]uint16
+	// Abs holds the ranges of the absolute axes.
+	Abs map[uint16]AbsInfo
+	// Props lists the input properties, e.g. "INPUT_PROP_DIRECT".
+	Props []string
+}
+
+// Supports reports whether the device emits the given event.
+func (dev InputDevice) Supports(typ, code uint16) bool {
+	for _, c := range dev.Events[typ] {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// InputDevices lists the kernel input devices.
+func (d Device) InputDevices() ([]InputDevice, error) {
+	out, err := d.RunShellCommand("getevent", "-p")
+	if err != nil {
+		return nil, err
+	}
+	return parseGeteventDevices(out), nil
+}
+
+var (
+	geteventAddDevice = regexp.MustCompile(`^add device \d+: (\S+)`)
+	geteventEventType = regexp.MustCompile(`^\s+([A-Z_]+|[0-9a-f]{4}) \(([0-9a-f]{4})\):(.*)$`)
+	geteventAbsAxis   = regexp.MustCompile(`([0-9a-f]{4})\s+: value (-?\d+), min (-?\d+), max (-?\d+), fuzz (-?\d+), flat (-?\d+), resolution (-?\d+)`)
+	geteventCode      = regexp.MustCompile(`^[0-9a-f]{4}$`)
+)
+
+// parseGeteventDevices parses the output of `getevent -p`:
+//
+//	add device 1: /dev/input/event2
+//	  name:     "virtio_input_multi_touch_1"
+//	  events:
+//	    KEY (0001): 014a
+//	    ABS (0003): 002f  : value 0, min 0, max 9, fuzz 0, flat 0, resolution 0
+//	                0035  : value 0, min 0, max 32767, fuzz 0, flat 0, resolution 0
+//	  input props:
+//	    INPUT_PROP_DIRECT
+func parseGeteventDevices(output string) []InputDevice {
+	var devices []InputDevice
+	var dev *InputDevice
+	var section string
+	var evType uint16
+
+	addCodes := func(fields string) {
+		if evType == EvAbs {
+			for _, m := range geteventAbsAxis.FindAllStringSubmatch(fields, -1) {
+				code, _ := strconv.ParseUint(m[1], 16, 16)
+				var v [6]int32
+				for i := range v {
+					n, _ := strconv.ParseInt(m[i+2], 10, 32)
+					v[i] = int32(n)
+				}
+				dev.Events[evType] = append(dev.Events[evType], uint16(code))
+				dev.Abs[uint16(code)] = AbsInfo{v[0], v[1], v[2], v[3], v[4], v[5]}
+			}
+			return
+		}
+		for _, f := range strings.Fields(fields) {
+			if geteventCode.MatchString(f) {
+				code, _ := strconv.ParseUint(f, 16, 16)
+				dev.Events[evType] = append(dev.Events[evType], uint16(code))
+			}
+		}
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := geteventAddDevice.FindStringSubmatch(line); m != nil {
+			devices = append(devices, InputDevice{Path: m[1], Events: map[uint16][]uint16{}, Abs: map[uint16]AbsInfo{}})
+			dev, section = &devices[len(devices)-1], ""
+			continue
+		}
+		if dev == nil {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "name:"):
+			dev.Name = strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "name:")), `"`)
+			section = ""
+		case trimmed == "events:":
+			section = "events"
+		case trimmed == "input props:":
+			section = "props"
+		case section == "events":
+			if m := geteventEventType.FindStringSubmatch(line); m != nil {
+				typ, _ := strconv.ParseUint(m[2], 16, 16)
+				evType = uint16(typ)
+				addCodes(m[3])
+			} else if strings.HasPrefix(line, "    ") {
+				addCodes(trimmed)
+			} else {
+				section = ""
+			}
+		case section == "props":
+			if strings.HasPrefix(trimmed, "INPUT_PROP_") {
+				dev.Props = append(dev.Props, trimmed)
+			}
+		}
+	}
+	return devices
+}
+
+// Touchscreen injects multi-touch gestures by writing raw input events to
+// a touchscreen's device node, bypassing the `input` command.
+type Touchscreen struct {
+	Device InputDevice
+
+	dev Device
+	// display size in pixels, to scale coordinates to the axis ranges
+	width, height int
+	// eventSize is the size of struct input_event for the device's ABI
+	eventSize int
+}
+
+// Touchscreen finds the device's multi-touch screen, preferring direct
+// input devices (INPUT_PROP_DIRECT) when there are several.
+func (d Device) Touchscreen() (*Touchscreen, error) {
+	devices, err := d.InputDevices()
+	if err != nil {
+		return nil, err
+	}
+	var found *InputDevice
+	for i, dev := range devices {
+		if !dev.Supports(EvAbs, AbsMTPositionX) || !dev.Supports(EvAbs, AbsMTPositionY) {
+			continue
+		}
+		direct := false
+		for _, p := range dev.Props {
+			direct = direct || p == "INPUT_PROP_DIRECT"
+		}
+		if found == nil || direct {
+			found = &devices[i]
+		}
+		if direct {
+			break
+		}
+	}
+	if found == nil {
+		return nil, errors.New("no multi-touch input device found")
+	}
+
+	width, height, err := d.displaySize()
+	if err != nil {
+		return nil, err
+	}
+	eventSize, err := d.inputEventSize()
+	if err != nil {
+		return nil, err
+	}
+	return &Touchscreen{Device: *found, dev: d, width: width, height: height, eventSize: eventSize}, nil
+}
+
+// inputEventSize returns the size of struct input_event for the device's
+// primary ABI, whose timeval is two longs.
+func (d Device) inputEventSize() (int, error) {
+	info, err := d.BuildInfo()
+	if err != nil {
+		return 0, err
+	}
+	if len(info.ABIs) != 0 && strings.Contains(info.ABIs[0], "64") {
+		return 24, nil
+	}
+	return 16, nil
+}
+
+var wmSize = regexp.MustCompile(`Physical size: (\d+)x(\d+)`)
+
+// displaySize returns the physical size of the default display.
+func (d Device) displaySize() (width, height int, err error) {
+	out, err := d.RunShellCommand("wm", "size")
+	if err != nil {
+		return 0, 0, err
+	}
+	m := wmSize.FindStringSubmatch(out)
+	if m == nil {
+		return 0, 0, fmt.Errorf("wm size: %s", strings.TrimSpace(out))
+	}
+	width, _ = strconv.Atoi(m[1])
+	height, _ = strconv.Atoi(m[2])
+	return width, height, nil
+}
+
+// Touch is a pointer touching the screen in a TouchFrame.
+type Touch struct {
+	// ID identifies the pointer across frames; it is used as the
+	// multi-touch slot, so it must be below the device's slot count.
+	ID int
+	// X and Y are display pixels in the panel's natural orientation.
+	X, Y int
+}
+
+// TouchFrame is the set of pointers down at one moment.
+type TouchFrame []Touch
+
+// Gesture plays frames, interval apart, as one multi-touch gesture: each
+// frame lists the pointers down, so pointers appear, move and lift between
+// frames. All pointers are lifted at the end, also when ctx is cancelled.
+// The events are streamed through a single connection, timed on the host.
+func (t *Touchscreen) Gesture(ctx context.Context, frames []TouchFrame, interval time.Duration) error {
+	batches := t.gestureEvents(frames)
+	r, w := io.Pipe()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i, batch := range batches {
+			if i > 0 {
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+					// lift all pointers rather than leave them stuck down
+					_, _ = w.Write(t.encode(t.releaseEvents(frames)))
+					_ = w.Close()
+					return
+				}
+			}
+			if _, err := w.Write(t.encode(batch)); err != nil {
+				_ = w.CloseWithError(err)
+				return
+			}
+		}
+		_ = w.Close()
+	}()
+	// the writer lifts the pointers on cancellation, so the connection must
+	// outlive ctx
+	err := t.dev.WriteInputEvents(context.Background(), t.Device.Path, r)
+	// unblock the writer if the events stopped being read early
+	_ = r.CloseWithError(err)
+	<-written
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// WriteInputEvents copies encoded input_event structs from r to the input
+// device node at path as they arrive, until r is exhausted or ctx is done.
+func (d Device) WriteInputEvents(ctx context.Context, path string, r io.Reader) error {
+	tp, err := d.openExec("cat > " + ShellQuote(path))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tp.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = tp.Close() })
+	defer stop()
+
+	out, err := execIn(tp, r)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return err
+	}
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("write %s: %s", path, msg)
+	}
+	return nil
+}
+
+// gestureEvents converts frames into batches of events, one batch per
+// frame plus a final one lifting every pointer. It uses the type B
+// multi-touch protocol.
+func (t *Touchscreen) gestureEvents(frames []TouchFrame) [][]InputEvent {
+	var batches [][]InputEvent
+	down := map[int]Touch{}
+	trackingID := int32(0)
+	if info, ok := t.Device.Abs[AbsMTTrackingID]; ok && info.Min > 0 {
+		trackingID = info.Min
+	}
+
+	for _, frame := range append(frames, TouchFrame{}) {
+		var events []InputEvent
+		abs := func(code uint16, value int32) {
+			events = append(events, InputEvent{Type: EvAbs, Code: code, Value: value})
+		}
+		current := map[int]Touch{}
+		for _, touch := range frame {
+			current[touch.ID] = touch
+		}
+
+		for _, id := range sortedTouchIDs(down) {
+			if _, ok := current[id]; !ok {
+				abs(AbsMTSlot, int32(id))
+				abs(AbsMTTrackingID, -1)
+			}
+		}
+		for _, touch := range frame {
+			prev, wasDown := down[touch.ID]
+			if wasDown && prev == touch {
+				continue
+			}
+			abs(AbsMTSlot, int32(touch.ID))
+			if !wasDown {
+				abs(AbsMTTrackingID, trackingID)
+				trackingID++
+				if t.Device.Supports(EvAbs, AbsMTTouchMajor) {
+					abs(AbsMTTouchMajor, t.Device.Abs[AbsMTTouchMajor].Max/8+1)
+				}
+				if t.Device.Supports(EvAbs, AbsMTPressure) {
+					abs(AbsMTPressure, t.Device.Abs[AbsMTPressure].Max/2+1)
+				}
+			}
+			if !wasDown || prev.X != touch.X {
+				abs(AbsMTPositionX, t.scale(touch.X, t.width, t.Device.Abs[AbsMTPositionX]))
+			}
+			if !wasDown || prev.Y != touch.Y {
+				abs(AbsMTPositionY, t.scale(touch.Y, t.height, t.Device.Abs[AbsMTPositionY]))
+			}
+		}
+
+		if t.Device.Supports(EvKey, BtnTouch) {
+			if len(down) == 0 && len(current) != 0 {
+				events = append(events, InputEvent{Type: EvKey, Code: BtnTouch, Value: 1})
+			} else if len(down) != 0 && len(current) == 0 {
+				events = append(events, InputEvent{Type: EvKey, Code: BtnTouch, Value: 0})
+			}
+		}
+		events = append(events, InputEvent{Type: EvSyn, Code: SynReport})
+		batches = append(batches, events)
+		down = current
+	}
+	return batches
+}
+
+// releaseEvents lifts every pointer used by frames, whichever are down.
+func (t *Touchscreen) releaseEvents(frames []TouchFrame) []InputEvent {
+	ids := map[int]Touch{}
+	for _, frame := range frames {
+		for _, touch := range frame {
+			ids[touch.ID] = touch
+		}
+	}
+	var events []InputEvent
+	for _, id := range sortedTouchIDs(ids) {
+		events = append(events,
+			InputEvent{Type: EvAbs, Code: AbsMTSlot, Value: int32(id)},
+			InputEvent{Type: EvAbs, Code: AbsMTTrackingID, Value: -1})
+	}
+	if t.Device.Supports(EvKey, BtnTouch) {
+		events = append(events, InputEvent{Type: EvKey, Code: BtnTouch, Value: 0})
+	}
+	return append(events, InputEvent{Type: EvSyn, Code: SynReport})
+}
+
+func sortedTouchIDs(touches map[int]Touch) []int {
+	ids := make([]int, 0, len(touches))
+	for id := range touches {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// scale maps a display coordinate to an axis value.
+func (t *Touchscreen) scale(v, size int, axis AbsInfo) int32 {
+	if size <= 1 || axis.Max <= axis.Min {
+		return int32(v)
+	}
+	return axis.Min + int32(int64(v)*int64(axis.Max-axis.Min)/int64(size-1))
+}
+
+// encode serializes events as struct input_event. The kernel stamps
+// injected events itself, so the time fields are left zero.
+func (t *Touchscreen) encode(events []InputEvent) []byte {
+	return encodeInputEvents(events, t.eventSize)
+}
+
+func encodeInputEvents(events []InputEvent, eventSize int) []byte {
+	var buf bytes.Buffer
+	timeval := make([]byte, eventSize-8)
+	var b [8]byte
+	for _, ev := range events {
+		buf.Write(timeval)
+		binary.LittleEndian.PutUint16(b[0:], ev.Type)
+		binary.LittleEndian.PutUint16(b[2:], ev.Code)
+		binary.LittleEndian.PutUint32(b[4:], uint32(ev.Value))
+		buf.Write(b[:])
+	}
+	return buf.Bytes()
+}
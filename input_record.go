@@ -0,0 +1,226 @@
+package gadb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InputEventStream delivers recorded input events.
+type InputEventStream struct {
+	*streamState
+	events chan InputEvent
+}
+
+// Events returns the channel of input events. It is closed when getevent
+// exits or the stream is cancelled, after which Err reports why.
+func (s *InputEventStream) Events() <-chan InputEvent {
+	return s.events
+}
+
+// RecordInput streams the events of the input device at path, or of all
+// input devices if path is empty, as reported by getevent.
+// Cancelling ctx, or calling Close, stops the recording.
+//
+// Events are read with `getevent -t` rather than `getevent -lt`: the
+// labelled output prints key values as DOWN/UP and cannot always be mapped
+// back to codes, while the numeric one replays exactly.
+func (d Device) RecordInput(ctx context.Context, path string) (*InputEventStream, error) {
+	cmd := "getevent -t"
+	if path != "" {
+		cmd += " " + ShellQuote(path)
+	}
+	lines, err := d.StreamShell(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &InputEventStream{streamState: newStreamState(ctx), events: make(chan InputEvent)}
+	s.closeOnDone(lines)
+	go func() {
+		defer close(s.events)
+		for line := range lines.Lines() {
+			ev, ok := parseGeteventLine(line.Text, path)
+			if !ok {
+				continue
+			}
+			if !s.emit(ev) {
+				break
+			}
+		}
+		s.finish(lines.Err())
+	}()
+	return s, nil
+}
+
+func (s *InputEventStream) emit(ev InputEvent) bool {
+	select {
+	case s.events <- ev:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+var geteventLine = regexp.MustCompile(`^\[\s*(\d+)\.(\d{6})\]\s+(?:(\S+):\s+)?([0-9a-f]{4}) ([0-9a-f]{4}) ([0-9a-f]{8})\s*$`)
+
+// parseGeteventLine parses an event printed by `getevent -t`, e.g.
+//
+//	[   18137.651722] /dev/input/event2: 0003 0035 00001c3f
+//
+// The device path is only printed when recording all devices; otherwise
+// it is taken from path.
+func parseGeteventLine(line, path string) (InputEvent, bool) {
+	m := geteventLine.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if m == nil {
+		return InputEvent{}, false
+	}
+	sec, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return InputEvent{}, false
+	}
+	usec, _ := strconv.ParseInt(m[2], 10, 64)
+	typ, _ := strconv.ParseUint(m[4], 16, 16)
+	code, _ := strconv.ParseUint(m[5], 16, 16)
+	value, _ := strconv.ParseUint(m[6], 16, 32)
+	ev := InputEvent{
+		Device:    m[3],
+		Timestamp: time.Duration(sec)*time.Second + time.Duration(usec)*time.Microsecond,
+		Type:      uint16(typ),
+		Code:      uint16(code),
+		Value:     int32(value),
+	}
+	if ev.Device == "" {
+		ev.Device = path
+	}
+	return ev, true
+}
+
+// SaveInputEvents writes events in the format of `getevent -t`, one per
+// line, so that recordings can also be made with adb shell directly.
+func SaveInputEvents(w io.Writer, events []InputEvent) error {
+	bw := bufio.NewWriter(w)
+	for _, ev := range events {
+		if ev.Device == "" {
+			return errors.New("save input events: event has no device")
+		}
+		_, _ = fmt.Fprintf(bw, "[%8d.%06d] %s: %04x %04x %08x\n",
+			ev.Timestamp/time.Second, ev.Timestamp%time.Second/time.Microsecond,
+			ev.Device, ev.Type, ev.Code, uint32(ev.Value))
+	}
+	return bw.Flush()
+}
+
+// LoadInputEvents reads events saved by SaveInputEvents or printed by
+// `getevent -t`. Lines other than events, such as getevent's device list,
+// are skipped.
+func LoadInputEvents(r io.Reader) ([]InputEvent, error) {
+	var events []InputEvent
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ev, ok := parseGeteventLine(scanner.Text(), "")
+		if !ok {
+			continue
+		}
+		if ev.Device == "" {
+			return nil, fmt.Errorf("load input events: no device in %q", scanner.Text())
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("load input events: %w", err)
+	}
+	return events, nil
+}
+
+// ReplayInput writes recorded events back to their devices, keeping the
+// intervals between them. Events are sent a report (SYN_REPORT) at a time,
+// timed on the host, through one connection per device node.
+// Cancelling ctx stops the replay, which may leave keys or pointers down.
+func (d Device) ReplayInput(ctx context.Context, events []InputEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	eventSize, err := d.inputEventSize()
+	if err != nil {
+		return err
+	}
+
+	writers := map[string]*io.PipeWriter{}
+	errs := make(chan error)
+	for _, ev := range events {
+		if _, ok := writers[ev.Device]; ok {
+			continue
+		}
+		r, w := io.Pipe()
+		writers[ev.Device] = w
+		go func(path string) {
+			err := d.WriteInputEvents(ctx, path, r)
+			_ = r.CloseWithError(err)
+			errs <- err
+		}(ev.Device)
+	}
+
+	start, origin := time.Now(), events[0].Timestamp
+	err = func() error {
+		for _, batch := range inputReports(events) {
+			if wait := time.Until(start.Add(batch[0].Timestamp - origin)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if _, err := writers[batch[0].Device].Write(encodeInputEvents(batch, eventSize)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	for _, w := range writers {
+		_ = w.CloseWithError(err)
+	}
+
+	var writeErrs []error
+	for range writers {
+		if err := <-errs; err != nil {
+			writeErrs = append(writeErrs, err)
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if len(writeErrs) != 0 {
+		return errors.Join(writeErrs...)
+	}
+	return err
+}
+
+// inputReports splits events into runs for one device ending with a
+// SYN_REPORT, which the kernel delivers together.
+func inputReports(events []InputEvent) [][]InputEvent {
+	var reports [][]InputEvent
+	pending := map[string][]InputEvent{}
+	for _, ev := range events {
+		report := append(pending[ev.Device], ev)
+		if ev.Type == EvSyn && ev.Code == SynReport {
+			reports = append(reports, report)
+			report = nil
+		}
+		pending[ev.Device] = report
+	}
+	// flush incomplete reports in the order they started
+	for _, ev := range events {
+		if report := pending[ev.Device]; len(report) != 0 && report[0] == ev {
+			reports = append(reports, report)
+			delete(pending, ev.Device)
+		}
+	}
+	return reports
+}
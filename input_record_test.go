@@ -0,0 +1,77 @@
+package gadb
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGeteventRecording = `add device 1: /dev/input/event3
+  name:     "gpio-keys"
+could not get driver version for /dev/input/mice, Not a typewriter
+[   18137.651722] /dev/input/event2: 0003 0039 00000000
+[   18137.651722] /dev/input/event2: 0003 0035 00001c3f
+[   18137.651722] /dev/input/event2: 0000 0000 00000000
+[   18137.702004] /dev/input/event3: 0001 0072 00000001
+[   18137.702004] /dev/input/event3: 0000 0000 00000000
+[   18137.733100] /dev/input/event2: 0003 0039 ffffffff
+`
+
+func TestLoadInputEvents(t *testing.T) {
+	events, err := LoadInputEvents(strings.NewReader(testGeteventRecording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("loaded %d events, want 6", len(events))
+	}
+	want := InputEvent{
+		Device:    "/dev/input/event2",
+		Timestamp: 18137*time.Second + 651722*time.Microsecond,
+		Type:      EvAbs,
+		Code:      AbsMTPositionX,
+		Value:     0x1c3f,
+	}
+	if events[1] != want {
+		t.Errorf("events[1] = %+v, want %+v", events[1], want)
+	}
+	if events[5].Value != -1 {
+		t.Errorf("events[5].Value = %d, want -1", events[5].Value)
+	}
+
+	var buf bytes.Buffer
+	if err = SaveInputEvents(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadInputEvents(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reloaded, events) {
+		t.Errorf("round trip = %+v, want %+v", reloaded, events)
+	}
+}
+
+func Test_parseGeteventLine(t *testing.T) {
+	ev, ok := parseGeteventLine("[    5.000010] 0001 014a 00000001\r", "/dev/input/event1")
+	want := InputEvent{Device: "/dev/input/event1", Timestamp: 5*time.Second + 10*time.Microsecond, Type: EvKey, Code: BtnTouch, Value: 1}
+	if !ok || ev != want {
+		t.Errorf("parseGeteventLine() = %+v, %v, want %+v", ev, ok, want)
+	}
+	if _, ok = parseGeteventLine("add device 1: /dev/input/event1", ""); ok {
+		t.Error("parseGeteventLine() accepted a device line")
+	}
+}
+
+func Test_inputReports(t *testing.T) {
+	events, err := LoadInputEvents(strings.NewReader(testGeteventRecording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]InputEvent{events[0:3], events[3:5], events[5:6]}
+	if got := inputReports(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("inputReports() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package gadb
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_textCommands(t *testing.T) {
+	got, err := textCommands("hi there\n50%s\tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"text", "hi%sthere"},
+		{"keyevent", "66"},
+		{"text", "50%"},
+		{"text", "s"},
+		{"keyevent", "61"},
+		{"text", "ok"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("textCommands() = %q, want %q", got, want)
+	}
+
+	if _, err = textCommands("héllo"); err == nil {
+		t.Error("textCommands() accepted non-ASCII text")
+	}
+}
+
+func TestInputOptions_args(t *testing.T) {
+	got := ShellJoin(InputOptions{Source: SourceMouse, DisplayID: 2}.args()...)
+	if want := "mouse -d 2"; got != want {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+}
+
+const testGeteventDevices = `add device 1: /dev/input/event3
+  name:     "gpio-keys"
+  events:
+    KEY (0001): 0072  0073  0074
+  input props:
+    <none>
+could not get driver version for /dev/input/mice, Not a typewriter
+add device 2: /dev/input/event2
+  name:     "virtio_input_multi_touch_1"
+  events:
+    KEY (0001): 014a
+    ABS (0003): 002f  : value 0, min 0, max 9, fuzz 0, flat 0, resolution 0
+                0035  : value 0, min 0, max 32767, fuzz 0, flat 0, resolution 0
+                0036  : value 0, min 0, max 32767, fuzz 0, flat 0, resolution 0
+                0039  : value 0, min 0, max 65535, fuzz 0, flat 0, resolution 0
+  input props:
+    INPUT_PROP_DIRECT
+`
+
+func Test_parseGeteventDevices(t *testing.T) {
+	devices := parseGeteventDevices(testGeteventDevices)
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2", len(devices))
+	}
+
+	keys := devices[0]
+	if keys.Path != "/dev/input/event3" || keys.Name != "gpio-keys" {
+		t.Errorf("device 0 = %s %q", keys.Path, keys.Name)
+	}
+	if want := []uint16{0x72, 0x73, 0x74}; !reflect.DeepEqual(keys.Events[EvKey], want) {
+		t.Errorf("device 0 keys = %x, want %x", keys.Events[EvKey], want)
+	}
+	if len(keys.Props) != 0 {
+		t.Errorf("device 0 props = %q", keys.Props)
+	}
+
+	touch := devices[1]
+	if !touch.Supports(EvKey, BtnTouch) || !touch.Supports(EvAbs, AbsMTPositionY) {
+		t.Errorf("device 1 events = %x", touch.Events)
+	}
+	if want := (AbsInfo{Max: 32767}); touch.Abs[AbsMTPositionX] != want {
+		t.Errorf("device 1 ABS_MT_POSITION_X = %+v, want %+v", touch.Abs[AbsMTPositionX], want)
+	}
+	if want := []string{"INPUT_PROP_DIRECT"}; !reflect.DeepEqual(touch.Props, want) {
+		t.Errorf("device 1 props = %q, want %q", touch.Props, want)
+	}
+}
+
+func TestTouchscreen_gestureEvents(t *testing.T) {
+	ts := &Touchscreen{
+		Device:    parseGeteventDevices(testGeteventDevices)[1],
+		width:     1001,
+		height:    2001,
+		eventSize: 24,
+	}
+	abs := func(code uint16, value int32) InputEvent {
+		return InputEvent{Type: EvAbs, Code: code, Value: value}
+	}
+	syn := InputEvent{Type: EvSyn, Code: SynReport}
+
+	got := ts.gestureEvents([]TouchFrame{
+		{{ID: 0, X: 0, Y: 2000}},
+		{{ID: 0, X: 1000, Y: 2000}, {ID: 1, X: 500, Y: 1000}},
+		{{ID: 1, X: 500, Y: 1000}},
+	})
+	want := [][]InputEvent{
+		{
+			abs(AbsMTSlot, 0), abs(AbsMTTrackingID, 0), abs(AbsMTPositionX, 0), abs(AbsMTPositionY, 32767),
+			{Type: EvKey, Code: BtnTouch, Value: 1}, syn,
+		},
+		{
+			abs(AbsMTSlot, 0), abs(AbsMTPositionX, 32767),
+			abs(AbsMTSlot, 1), abs(AbsMTTrackingID, 1), abs(AbsMTPositionX, 16383), abs(AbsMTPositionY, 16383),
+			syn,
+		},
+		{abs(AbsMTSlot, 0), abs(AbsMTTrackingID, -1), syn},
+		{abs(AbsMTSlot, 1), abs(AbsMTTrackingID, -1), {Type: EvKey, Code: BtnTouch, Value: 0}, syn},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("gestureEvents() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func Test_encodeInputEvents(t *testing.T) {
+	events := []InputEvent{{Type: EvAbs, Code: AbsMTTrackingID, Value: -1}, {}}
+	for _, size := range []int{16, 24} {
+		data := encodeInputEvents(events, size)
+		if len(data) != 2*size {
+			t.Fatalf("size %d: encoded %d bytes", size, len(data))
+		}
+		ev := data[size-8:]
+		if typ, code := binary.LittleEndian.Uint16(ev), binary.LittleEndian.Uint16(ev[2:]); typ != EvAbs || code != AbsMTTrackingID {
+			t.Errorf("size %d: type %x code %x", size, typ, code)
+		}
+		if v := int32(binary.LittleEndian.Uint32(ev[4:])); v != -1 {
+			t.Errorf("size %d: value %d", size, v)
+		}
+	}
+}
+
+func TestTouchscreen_Gesture_writeError(t *testing.T) {
+	// nothing listens on the port, so the events cannot be written
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	_ = ln.Close()
+	ts := &Touchscreen{
+		Device:    parseGeteventDevices(testGeteventDevices)[1],
+		dev:       Device{adbClient: Client{host: addr.IP.String(), port: addr.Port}, serial: "fake"},
+		width:     1000,
+		height:    2000,
+		eventSize: 24,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ts.Gesture(context.Background(), []TouchFrame{{{X: 10, Y: 10}}, {{X: 20, Y: 20}}}, time.Millisecond)
+	}()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("Gesture() = nil, want the connection error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Gesture() blocked after the write failed")
+	}
+}
@@ -0,0 +1,340 @@
+package gadb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InstallOptions configures how a package is installed.
+type InstallOptions struct {
+	// Replace reinstalls an existing app, keeping its data (-r).
+	Replace bool
+	// AllowDowngrade allows a lower version code to replace the installed one (-d).
+	AllowDowngrade bool
+	// GrantPermissions grants all runtime permissions listed in the manifest (-g).
+	GrantPermissions bool
+	// AllowTest allows packages marked testOnly (-t).
+	AllowTest bool
+	// Instant installs the package as an instant app (--instant).
+	Instant bool
+	// User installs for the given user id or "all" (--user), instead of
+	// the package manager's default.
+	User string
+}
+
+func (opts InstallOptions) args() []string {
+	var args []string
+	if opts.Replace {
+		args = append(args, "-r")
+	}
+	if opts.AllowDowngrade {
+		args = append(args, "-d")
+	}
+	if opts.GrantPermissions {
+		args = append(args, "-g")
+	}
+	if opts.AllowTest {
+		args = append(args, "-t")
+	}
+	if opts.Instant {
+		args = append(args, "--instant")
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	return args
+}
+
+// InstallError is returned when the package manager rejects an install.
+type InstallError struct {
+	// Code is the failure code, e.g. "INSTALL_FAILED_VERSION_DOWNGRADE",
+	// or empty when the output did not carry one.
+	Code    string
+	Message string
+}
+
+func (e *InstallError) Error() string {
+	if e.Code == "" {
+		return "install failed: " + e.Message
+	}
+	if e.Message == "" {
+		return "install failed: " + e.Code
+	}
+	return fmt.Sprintf("install failed: %s: %s", e.Code, e.Message)
+}
+
+var installFailure = regexp.MustCompile(`Failure \[([A-Z0-9_]+)(?::\s*(.*?))?\]`)
+
+// parseInstallResult interprets the output of `pm install` and friends.
+func parseInstallResult(output string) error {
+	output = strings.TrimSpace(output)
+	if m := installFailure.FindStringSubmatch(output); m != nil {
+		return &InstallError{Code: m[1], Message: m[2]}
+	}
+	if strings.Contains(output, "Success") {
+		return nil
+	}
+	return &InstallError{Message: output}
+}
+
+// Install installs the APK read from apk, which must supply size bytes.
+// The APK is streamed straight to the package manager when the device
+// supports it (abb_exec or cmd); older devices fall back to pushing it to
+// /data/local/tmp and running `pm install`.
+// A rejected install is reported as an *InstallError.
+func (d Device) Install(ctx context.Context, apk io.Reader, size int64, opts InstallOptions) error {
+	features, err := d.featureSet()
+	if err != nil {
+		return err
+	}
+
+	apk = io.LimitReader(apk, size)
+	args := append([]string{"install", "-S", strconv.FormatInt(size, 10)}, opts.args()...)
+	out, err := d.packageStream(ctx, features, args, apk)
+	if err == errNoPackageStream {
+		return d.installPushed(ctx, apk, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return parseInstallResult(string(out))
+}
+
+var errNoPackageStream = errors.New("device cannot stream to the package manager")
+
+// packageStream runs a package manager command with stdin piped from r,
+// through abb_exec when available or `cmd package` otherwise. It returns
+// errNoPackageStream on devices supporting neither.
+func (d Device) packageStream(ctx context.Context, features map[string]bool, args []string, r io.Reader) ([]byte, error) {
+	var service string
+	switch {
+	case features["abb_exec"]:
+		service = "abb_exec:" + strings.Join(append([]string{"package"}, args...), "\x00")
+	case features["cmd"]:
+		service = "exec:cmd " + ShellJoin(append([]string{"package"}, args...)...)
+	default:
+		return nil, errNoPackageStream
+	}
+
+	tp, err := d.openService(service)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tp.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = tp.Close() })
+	defer stop()
+
+	out, err := execIn(tp, r)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return out, err
+}
+
+// installPushed installs by pushing the APK to a temporary file first.
+func (d Device) installPushed(ctx context.Context, apk io.Reader, opts InstallOptions) error {
+	remotePath, err := d.pushTemp(ctx, apk, ".apk")
+	if err != nil {
+		return err
+	}
+	defer func() { _, _ = d.RunShellCommand("rm", "-f", ShellQuote(remotePath)) }()
+
+	out, err := d.RunShellCommand("pm", ShellJoin(append(append([]string{"install"}, opts.args()...), remotePath)...))
+	if err != nil {
+		return err
+	}
+	return parseInstallResult(out)
+}
+
+// pushTemp pushes r to a new file under /data/local/tmp and returns its path.
+func (d Device) pushTemp(ctx context.Context, r io.Reader, suffix string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	remotePath := fmt.Sprintf("/data/local/tmp/gadb-%d%s", time.Now().UnixNano(), suffix)
+	if err := d.Push(r, remotePath, time.Now()); err != nil {
+		return "", fmt.Errorf("push %s: %w", remotePath, err)
+	}
+	return remotePath, nil
+}
+
+// APKSource is one file of a multi-APK install.
+type APKSource struct {
+	// Name identifies the file within the install session, e.g. "base.apk"
+	// or "split_config.arm64_v8a.apk". Names ending in ".apex" mark APEX
+	// packages in InstallMultiPackage.
+	Name   string
+	Reader io.Reader
+	Size   int64
+}
+
+// InstallMultiple installs a base APK together with its split APKs as one
+// package, using a package manager install session. If any step fails the
+// session is abandoned, leaving the device unchanged.
+func (d Device) InstallMultiple(ctx context.Context, apks []APKSource, opts InstallOptions) error {
+	features, err := d.featureSet()
+	if err != nil {
+		return err
+	}
+	session, err := d.createInstallSession(features, opts.args())
+	if err != nil {
+		return err
+	}
+	if err = d.writeInstallSession(ctx, features, session, apks); err == nil {
+		err = d.commitInstallSession(features, session)
+	}
+	if err != nil {
+		d.abandonInstallSessions(features, session)
+	}
+	return err
+}
+
+// InstallMultiPackage installs several packages atomically in a
+// --multi-package session, e.g. an APEX together with the APKs depending
+// on it. Each element of packages holds the files of one package.
+// If any step fails, all sessions are abandoned.
+func (d Device) InstallMultiPackage(ctx context.Context, packages [][]APKSource, opts InstallOptions) error {
+	features, err := d.featureSet()
+	if err != nil {
+		return err
+	}
+	parent, err := d.createInstallSession(features, append([]string{"--multi-package"}, opts.args()...))
+	if err != nil {
+		return err
+	}
+
+	children := make([]string, 0, len(packages))
+	err = func() error {
+		for _, apks := range packages {
+			args := opts.args()
+			for _, apk := range apks {
+				if strings.HasSuffix(apk.Name, ".apex") {
+					args = append(args, "--apex")
+					break
+				}
+			}
+			child, err := d.createInstallSession(features, args)
+			if err != nil {
+				return err
+			}
+			children = append(children, child)
+			if err = d.writeInstallSession(ctx, features, child, apks); err != nil {
+				return err
+			}
+		}
+		out, err := d.pm(features, append([]string{"install-add-session", parent}, children...)...)
+		if err != nil {
+			return err
+		}
+		// install-add-session prints nothing on success on older releases
+		if out = strings.TrimSpace(out); out != "" {
+			if err = parseInstallResult(out); err != nil {
+				return err
+			}
+		}
+		return d.commitInstallSession(features, parent)
+	}()
+	if err != nil {
+		d.abandonInstallSessions(features, append([]string{parent}, children...)...)
+	}
+	return err
+}
+
+var installSessionID = regexp.MustCompile(`created install session \[(\d+)\]`)
+
+func (d Device) createInstallSession(features map[string]bool, args []string) (string, error) {
+	out, err := d.pm(features, append([]string{"install-create"}, args...)...)
+	if err != nil {
+		return "", err
+	}
+	return parseInstallSession(out)
+}
+
+// parseInstallSession returns the session ID printed by install-create,
+// "Success: created install session [1234]". Anything else is a failure,
+// such as "Failure [...]" or, from older releases, a bare "Error: ..." or
+// "error: ..." line.
+func parseInstallSession(output string) (string, error) {
+	if m := installSessionID.FindStringSubmatch(output); m != nil {
+		return m[1], nil
+	}
+	err := parseInstallResult(output)
+	if err == nil {
+		err = &InstallError{Message: strings.TrimSpace(output)}
+	}
+	return "", err
+}
+
+func (d Device) writeInstallSession(ctx context.Context, features map[string]bool, session string, apks []APKSource) error {
+	for i, apk := range apks {
+		name := apk.Name
+		if name == "" {
+			name = fmt.Sprintf("split_%d.apk", i)
+		}
+		r := io.LimitReader(apk.Reader, apk.Size)
+
+		args := []string{"install-write", "-S", strconv.FormatInt(apk.Size, 10), session, name, "-"}
+		out, err := d.packageStream(ctx, features, args, r)
+		if err == errNoPackageStream {
+			out, err = d.installWritePushed(ctx, features, session, name, r)
+		}
+		if err != nil {
+			return fmt.Errorf("install-write %s: %w", name, err)
+		}
+		if err = parseInstallResult(string(out)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d Device) installWritePushed(ctx context.Context, features map[string]bool, session, name string, r io.Reader) ([]byte, error) {
+	remotePath, err := d.pushTemp(ctx, r, ".apk")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _, _ = d.RunShellCommand("rm", "-f", ShellQuote(remotePath)) }()
+	out, err := d.pm(features, "install-write", session, name, remotePath)
+	return []byte(out), err
+}
+
+func (d Device) commitInstallSession(features map[string]bool, session string) error {
+	out, err := d.pm(features, "install-commit", session)
+	if err != nil {
+		return err
+	}
+	return parseInstallResult(out)
+}
+
+// abandonInstallSessions abandons sessions on a best-effort basis; a
+// session that was already committed or abandoned is silently skipped.
+func (d Device) abandonInstallSessions(features map[string]bool, sessions ...string) {
+	for _, session := range sessions {
+		if out, err := d.pm(features, "install-abandon", session); err != nil {
+			debugLog(fmt.Sprintf("install-abandon %s: %v %s", session, err, out))
+		}
+	}
+}
+
+// pm runs a package manager command and returns its combined output,
+// preferring the cmd service over spawning pm where supported.
+func (d Device) pm(features map[string]bool, args ...string) (string, error) {
+	return d.serviceCommand(features, "package", "pm", args...)
+}
+
+// serviceCommand runs a command of the system service named service
+// through cmd where supported, or else through its shell wrapper program,
+// and returns the combined output.
+func (d Device) serviceCommand(features map[string]bool, service, program string, args ...string) (string, error) {
+	if features["cmd"] {
+		result, err := d.Cmd(service, args...)
+		return string(result.Stdout) + string(result.Stderr), err
+	}
+	return d.RunShellCommand(program, ShellJoin(args...))
+}
@@ -0,0 +1,189 @@
+package gadb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_parseInstallResult(t *testing.T) {
+	tests := []struct {
+		output string
+		want   *InstallError
+	}{
+		{"Performing Streamed Install\nSuccess\n", nil},
+		{"Failure [INSTALL_FAILED_ALREADY_EXISTS: Attempt to re-install com.example without first uninstalling.]",
+			&InstallError{Code: "INSTALL_FAILED_ALREADY_EXISTS", Message: "Attempt to re-install com.example without first uninstalling."}},
+		{"adb: failed to install\nFailure [INSTALL_FAILED_OLDER_SDK]\n",
+			&InstallError{Code: "INSTALL_FAILED_OLDER_SDK"}},
+		{"Error: Unknown option: -x", &InstallError{Message: "Error: Unknown option: -x"}},
+	}
+	for _, tt := range tests {
+		err := parseInstallResult(tt.output)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", tt.output, err)
+			}
+			continue
+		}
+		var installErr *InstallError
+		if !errors.As(err, &installErr) || *installErr != *tt.want {
+			t.Errorf("%q: got %#v, want %#v", tt.output, err, tt.want)
+		}
+	}
+}
+
+func Test_parseInstallSession(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    string
+		wantErr *InstallError
+	}{
+		{"Success: created install session [1234]\n", "1234", nil},
+		{"Success: created install session [7]\r\n", "7", nil},
+		{"Failure [INSTALL_FAILED_INVALID_APK: no apex support]",
+			"", &InstallError{Code: "INSTALL_FAILED_INVALID_APK", Message: "no apex support"}},
+		{"Error: Unknown option --multi-package\n", "", &InstallError{Message: "Error: Unknown option --multi-package"}},
+		{"error: java.lang.SecurityException: Permission Denial [0]\n",
+			"", &InstallError{Message: "error: java.lang.SecurityException: Permission Denial [0]"}},
+		{"Success\n", "", &InstallError{Message: "Success"}},
+	}
+	for _, tt := range tests {
+		got, err := parseInstallSession(tt.output)
+		if tt.wantErr == nil {
+			if err != nil || got != tt.want {
+				t.Errorf("%q: got %q, %v, want %q", tt.output, got, err, tt.want)
+			}
+			continue
+		}
+		var installErr *InstallError
+		if got != "" || !errors.As(err, &installErr) || *installErr != *tt.wantErr {
+			t.Errorf("%q: got %q, %#v, want error %#v", tt.output, got, err, tt.wantErr)
+		}
+	}
+}
+
+// fakePackageManager answers `cmd package` install session commands,
+// failing the command named failOn.
+func fakePackageManager(failOn string) func(service string, conn net.Conn) {
+	var mu sync.Mutex
+	next := 10
+	return func(service string, conn net.Conn) {
+		args := fakeCommand(service)
+		if len(args) < 3 || args[0] != "cmd" || args[1] != "package" {
+			_, _ = io.WriteString(conn, "unexpected command")
+			return
+		}
+		if args[2] == failOn {
+			if args[2] == "install-write" {
+				_, _ = ioutil.ReadAll(conn)
+			}
+			_, _ = io.WriteString(conn, "Failure [INSTALL_FAILED_VERIFICATION_FAILURE: rejected]\n")
+			return
+		}
+		switch args[2] {
+		case "install-create":
+			mu.Lock()
+			next++
+			id := next
+			mu.Unlock()
+			_, _ = fmt.Fprintf(conn, "Success: created install session [%d]\n", id)
+		case "install-write":
+			data, _ := ioutil.ReadAll(conn)
+			_, _ = fmt.Fprintf(conn, "Success: streamed %d bytes\n", len(data))
+		case "install-add-session":
+			// older releases print nothing
+		default:
+			_, _ = io.WriteString(conn, "Success\n")
+		}
+	}
+}
+
+func testAPKSources() [][]APKSource {
+	return [][]APKSource{
+		{
+			{Name: "base.apk", Reader: strings.NewReader("base"), Size: 4},
+			{Name: "split_config.xxhdpi.apk", Reader: strings.NewReader("split"), Size: 5},
+		},
+		{{Name: "com.example.module.apex", Reader: strings.NewReader("apex"), Size: 4}},
+	}
+}
+
+func TestDevice_InstallMultiPackage(t *testing.T) {
+	tests := []struct {
+		failOn string
+		want   []string
+	}{
+		{"", []string{
+			"exec:cmd package install-create --multi-package -r",
+			"exec:cmd package install-create -r",
+			"exec:cmd package install-write -S 4 12 base.apk -",
+			"exec:cmd package install-write -S 5 12 split_config.xxhdpi.apk -",
+			"exec:cmd package install-create -r --apex",
+			"exec:cmd package install-write -S 4 13 com.example.module.apex -",
+			"exec:cmd package install-add-session 11 12 13",
+			"exec:cmd package install-commit 11",
+		}},
+		{"install-commit", []string{
+			"exec:cmd package install-create --multi-package -r",
+			"exec:cmd package install-create -r",
+			"exec:cmd package install-write -S 4 12 base.apk -",
+			"exec:cmd package install-write -S 5 12 split_config.xxhdpi.apk -",
+			"exec:cmd package install-create -r --apex",
+			"exec:cmd package install-write -S 4 13 com.example.module.apex -",
+			"exec:cmd package install-add-session 11 12 13",
+			"exec:cmd package install-commit 11",
+			"exec:cmd package install-abandon 11",
+			"exec:cmd package install-abandon 12",
+			"exec:cmd package install-abandon 13",
+		}},
+		{"install-write", []string{
+			"exec:cmd package install-create --multi-package -r",
+			"exec:cmd package install-create -r",
+			"exec:cmd package install-write -S 4 12 base.apk -",
+			"exec:cmd package install-abandon 11",
+			"exec:cmd package install-abandon 12",
+		}},
+	}
+	for _, tt := range tests {
+		f := &fakeADB{features: "cmd", handle: fakePackageManager(tt.failOn)}
+		d := newFakeADB(t, f)
+
+		err := d.InstallMultiPackage(context.Background(), testAPKSources(), InstallOptions{Replace: true})
+		var installErr *InstallError
+		if tt.failOn == "" && err != nil {
+			t.Errorf("InstallMultiPackage(): %v", err)
+		} else if tt.failOn != "" && (!errors.As(err, &installErr) || installErr.Code != "INSTALL_FAILED_VERIFICATION_FAILURE") {
+			t.Errorf("failing %s: got %v, want an install error", tt.failOn, err)
+		}
+		if got := f.calls(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("failing %q: calls =\n%s\nwant\n%s", tt.failOn, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestDevice_InstallMultiple_abandons(t *testing.T) {
+	f := &fakeADB{features: "cmd", handle: fakePackageManager("install-commit")}
+	d := newFakeADB(t, f)
+
+	if err := d.InstallMultiple(context.Background(), testAPKSources()[0], InstallOptions{}); err == nil {
+		t.Fatal("InstallMultiple() succeeded")
+	}
+	want := []string{
+		"exec:cmd package install-create",
+		"exec:cmd package install-write -S 4 11 base.apk -",
+		"exec:cmd package install-write -S 5 11 split_config.xxhdpi.apk -",
+		"exec:cmd package install-commit 11",
+		"exec:cmd package install-abandon 11",
+	}
+	if got := f.calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
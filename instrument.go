@@ -0,0 +1,545 @@
+package gadb
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InstrumentOptions configures an instrumentation run.
+type InstrumentOptions struct {
+	// Classes runs only the given test classes or methods, e.g.
+	// "com.example.FooTest" or "com.example.FooTest#testBar".
+	Classes    []string
+	NotClasses []string
+	// Packages runs only the tests in the given Java packages.
+	Packages    []string
+	NotPackages []string
+	// Annotation runs only tests annotated with the given annotation class.
+	Annotation    string
+	NotAnnotation string
+
+	// NumShards splits the tests into that many shards, of which only the
+	// one numbered ShardIndex (from 0) is run.
+	NumShards  int
+	ShardIndex int
+
+	// Coverage enables code coverage collection, written on the device to
+	// CoverageFile or else to the runner's default location.
+	Coverage     bool
+	CoverageFile string
+
+	// Args holds further runner arguments, passed as -e key value.
+	Args map[string]string
+	// User runs the instrumentation as the given user id (--user).
+	User string
+	// NoWindowAnimation disables window animations during the run.
+	NoWindowAnimation bool
+}
+
+func (opts InstrumentOptions) args() []string {
+	args := []string{"instrument", "-r", "-w"}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	if opts.NoWindowAnimation {
+		args = append(args, "--no-window-animation")
+	}
+	runnerArg := func(key, value string) {
+		if value != "" {
+			args = append(args, "-e", key, value)
+		}
+	}
+	runnerArg("class", strings.Join(opts.Classes, ","))
+	runnerArg("notClass", strings.Join(opts.NotClasses, ","))
+	runnerArg("package", strings.Join(opts.Packages, ","))
+	runnerArg("notPackage", strings.Join(opts.NotPackages, ","))
+	runnerArg("annotation", opts.Annotation)
+	runnerArg("notAnnotation", opts.NotAnnotation)
+	if opts.NumShards > 0 {
+		runnerArg("numShards", strconv.Itoa(opts.NumShards))
+		runnerArg("shardIndex", strconv.Itoa(opts.ShardIndex))
+	}
+	if opts.Coverage {
+		runnerArg("coverage", "true")
+		runnerArg("coverageFile", opts.CoverageFile)
+	}
+	keys := make([]string, 0, len(opts.Args))
+	for key := range opts.Args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-e", key, opts.Args[key])
+	}
+	return args
+}
+
+// TestStatus is the state a test reports.
+type TestStatus string
+
+const (
+	TestStarted           TestStatus = "started"
+	TestPassed            TestStatus = "passed"
+	TestFailed            TestStatus = "failed"
+	TestIgnored           TestStatus = "ignored"
+	TestAssumptionFailure TestStatus = "assumption failure"
+)
+
+// TestID identifies a test method.
+type TestID struct {
+	Class  string
+	Method string
+}
+
+func (id TestID) String() string {
+	return id.Class + "#" + id.Method
+}
+
+// TestEvent reports a test starting or finishing.
+type TestEvent struct {
+	Test   TestID
+	Status TestStatus
+	// Stack is the failure's stack trace, for failed tests and assumption
+	// failures.
+	Stack string
+	// Current is the test's 1-based position in the run, and Total the
+	// number of tests in it.
+	Current int
+	Total   int
+	// Time is when the event was received.
+	Time time.Time
+	// Duration is the time since the test started, for finished tests.
+	Duration time.Duration
+}
+
+// TestResult is the outcome of a test.
+type TestResult struct {
+	Test     TestID
+	Status   TestStatus
+	Stack    string
+	Duration time.Duration
+	// Device is the serial of the device the test ran on.
+	Device string
+	// Retries counts the times the test was run again after failing, by
+	// RunSharded.
+	Retries int
+}
+
+// InstrumentResult is the outcome of an instrumentation run.
+type InstrumentResult struct {
+	// Runner is the instrumentation component, e.g.
+	// "com.example.test/androidx.test.runner.AndroidJUnitRunner".
+	Runner string
+	Tests  []TestResult
+	// Code is the INSTRUMENTATION_CODE, -1 (Activity.RESULT_OK) when the
+	// instrumentation finished normally.
+	Code int
+	// Values holds the INSTRUMENTATION_RESULT bundle, e.g. the runner's
+	// "stream" summary.
+	Values map[string]string
+	// RunError describes why the run failed as a whole, e.g. because the
+	// app crashed or the runner was not found, or is empty.
+	RunError string
+	Duration time.Duration
+}
+
+// Passed reports whether the run completed without failing tests.
+func (r *InstrumentResult) Passed() bool {
+	if r.RunError != "" {
+		return false
+	}
+	for _, t := range r.Tests {
+		if t.Status == TestFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the results of the failed tests.
+func (r *InstrumentResult) Failed() []TestResult {
+	var failed []TestResult
+	for _, t := range r.Tests {
+		if t.Status == TestFailed {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+// InstrumentStream delivers the events of an instrumentation run.
+type InstrumentStream struct {
+	*streamState
+	events chan TestEvent
+	result InstrumentResult
+}
+
+// Events returns the channel of test events. It is closed when the run
+// ends, after which Result and Err are available.
+func (s *InstrumentStream) Events() <-chan TestEvent {
+	return s.events
+}
+
+// Result waits for the run to end and returns its outcome. Transport
+// failures are reported by Err; a run that failed on the device, such as
+// by crashing, is reported in the result's RunError.
+func (s *InstrumentStream) Result() *InstrumentResult {
+	<-s.done
+	return &s.result
+}
+
+// Instrument runs the instrumentation runner, e.g.
+// "com.example.test/androidx.test.runner.AndroidJUnitRunner", with
+// `am instrument -r -w` and streams per-test events as they are reported.
+func (d Device) Instrument(ctx context.Context, runner string, opts InstrumentOptions) (*InstrumentStream, error) {
+	lines, err := d.StreamShell(ctx, "am "+ShellJoin(append(opts.args(), runner)...))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &InstrumentStream{streamState: newStreamState(ctx), events: make(chan TestEvent)}
+	s.closeOnDone(lines)
+	go func() {
+		defer close(s.events)
+		start := time.Now()
+		p := newInstrumentParser(d.serial)
+		emit := func(events []TestEvent) bool {
+			for _, ev := range events {
+				select {
+				case s.events <- ev:
+				case <-s.ctx.Done():
+					return false
+				}
+			}
+			return true
+		}
+		for line := range lines.Lines() {
+			if !emit(p.feed(line.Text, time.Now())) {
+				break
+			}
+		}
+		err := lines.Err()
+		if s.ctx.Err() == nil {
+			emit(p.finish(time.Now()))
+		}
+		s.result = p.result
+		s.result.Runner = runner
+		s.result.Duration = time.Since(start)
+		s.finish(err)
+	}()
+	return s, nil
+}
+
+const (
+	instrumentStatus     = "INSTRUMENTATION_STATUS: "
+	instrumentStatusCode = "INSTRUMENTATION_STATUS_CODE: "
+	instrumentResult     = "INSTRUMENTATION_RESULT: "
+	instrumentCode       = "INSTRUMENTATION_CODE: "
+	instrumentFailed     = "INSTRUMENTATION_FAILED: "
+	instrumentAborted    = "INSTRUMENTATION_ABORTED: "
+)
+
+// instrumentParser turns `am instrument -r` output into test events. The
+// output is made of status bundles, each a list of key=value lines whose
+// values may continue over further lines, ended by a status code:
+//
+//	INSTRUMENTATION_STATUS: class=com.example.FooTest
+//	INSTRUMENTATION_STATUS: test=testBar
+//	INSTRUMENTATION_STATUS: stack=java.lang.AssertionError
+//		at com.example.FooTest.testBar(FooTest.java:12)
+//	INSTRUMENTATION_STATUS_CODE: -2
+//
+// The run ends with an INSTRUMENTATION_RESULT bundle and an
+// INSTRUMENTATION_CODE.
+type instrumentParser struct {
+	serial string
+	result InstrumentResult
+
+	status map[string]string
+	// values and key are the bundle and key continuation lines belong to
+	values map[string]string
+	key    string
+
+	running   *TestEvent
+	numTests  int
+	completed bool
+}
+
+func newInstrumentParser(serial string) *instrumentParser {
+	p := &instrumentParser{serial: serial, status: map[string]string{}}
+	p.result.Values = map[string]string{}
+	return p
+}
+
+func (p *instrumentParser) feed(line string, now time.Time) []TestEvent {
+	switch {
+	case strings.HasPrefix(line, instrumentStatus):
+		p.setValue(p.status, strings.TrimPrefix(line, instrumentStatus))
+	case strings.HasPrefix(line, instrumentResult):
+		p.setValue(p.result.Values, strings.TrimPrefix(line, instrumentResult))
+	case strings.HasPrefix(line, instrumentStatusCode):
+		code, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, instrumentStatusCode)))
+		status := p.status
+		p.status, p.values, p.key = map[string]string{}, nil, ""
+		return p.statusEvents(code, status, now)
+	case strings.HasPrefix(line, instrumentCode):
+		p.result.Code, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, instrumentCode)))
+		p.values, p.key = nil, ""
+		p.completed = true
+	case strings.HasPrefix(line, instrumentFailed), strings.HasPrefix(line, instrumentAborted):
+		p.setRunError(strings.TrimSpace(line[strings.Index(line, ": ")+2:]))
+		p.values, p.key = nil, ""
+	case p.values != nil:
+		p.values[p.key] += "\n" + line
+	case strings.HasPrefix(line, "onError: "), strings.HasPrefix(line, "Error: "):
+		// am reports a missing runner or package this way
+		p.setRunError(strings.TrimSpace(line))
+	}
+	return nil
+}
+
+func (p *instrumentParser) setValue(values map[string]string, kv string) {
+	parts := strings.SplitN(kv, "=", 2)
+	if len(parts) != 2 {
+		parts = append(parts, "")
+	}
+	values[parts[0]] = parts[1]
+	p.values, p.key = values, parts[0]
+}
+
+func (p *instrumentParser) setRunError(msg string) {
+	if p.result.RunError == "" {
+		p.result.RunError = msg
+	}
+}
+
+func (p *instrumentParser) statusEvents(code int, status map[string]string, now time.Time) []TestEvent {
+	id := TestID{Class: status["class"], Method: status["test"]}
+	if id.Class == "" {
+		return nil
+	}
+	ev := TestEvent{Test: id, Stack: status["stack"], Time: now}
+	ev.Current, _ = strconv.Atoi(status["current"])
+	ev.Total, _ = strconv.Atoi(status["numtests"])
+	if ev.Total > 0 {
+		p.numTests = ev.Total
+	}
+
+	switch code {
+	case 1:
+		ev.Status = TestStarted
+		var events []TestEvent
+		if p.running != nil {
+			events = p.end(TestFailed, "test did not report its result", now)
+		}
+		p.running = &ev
+		return append(events, ev)
+	case 0:
+		ev.Status = TestPassed
+	case -1, -2:
+		ev.Status = TestFailed
+	case -3:
+		ev.Status = TestIgnored
+	case -4:
+		ev.Status = TestAssumptionFailure
+	default:
+		// e.g. 2, in progress: carries output only
+		return nil
+	}
+	var events []TestEvent
+	if p.running != nil {
+		if p.running.Test == id {
+			ev.Duration = now.Sub(p.running.Time)
+			p.running = nil
+		} else {
+			// another test finishing means the running one's result was lost
+			events = p.end(TestFailed, "test did not report its result", now)
+		}
+	}
+	p.result.Tests = append(p.result.Tests, TestResult{
+		Test:     id,
+		Status:   ev.Status,
+		Stack:    ev.Stack,
+		Duration: ev.Duration,
+		Device:   p.serial,
+	})
+	return append(events, ev)
+}
+
+// end finishes the running test with the given status.
+func (p *instrumentParser) end(status TestStatus, stack string, now time.Time) []TestEvent {
+	running := p.running
+	p.running = nil
+	ev := TestEvent{
+		Test:     running.Test,
+		Status:   status,
+		Stack:    stack,
+		Current:  running.Current,
+		Total:    running.Total,
+		Time:     now,
+		Duration: now.Sub(running.Time),
+	}
+	p.result.Tests = append(p.result.Tests, TestResult{
+		Test:     ev.Test,
+		Status:   status,
+		Stack:    stack,
+		Duration: ev.Duration,
+		Device:   p.serial,
+	})
+	return []TestEvent{ev}
+}
+
+// finish is called once the output ends. A test still running then is
+// reported as failed, with the reason the run ended.
+func (p *instrumentParser) finish(now time.Time) []TestEvent {
+	if msg := p.result.Values["shortMsg"]; msg != "" {
+		p.setRunError(msg)
+	}
+	if !p.completed {
+		p.setRunError("instrumentation did not complete")
+	}
+	var events []TestEvent
+	if p.running != nil {
+		reason := p.result.RunError
+		if reason == "" {
+			reason = "test did not report its result"
+		}
+		events = p.end(TestFailed, reason, now)
+	}
+	if received := len(p.result.Tests); p.result.RunError == "" && received < p.numTests {
+		p.setRunError(fmt.Sprintf("expected %d tests, received %d", p.numTests, received))
+	}
+	return events
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr,omitempty"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",cdata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// stackMessage splits the first line of a Java stack trace, e.g.
+// "java.lang.AssertionError: expected:<1> but was:<2>", into the exception
+// type and message.
+func stackMessage(stack string) *junitMessage {
+	first := strings.SplitN(stack, "\n", 2)[0]
+	m := &junitMessage{Message: first, Text: stack}
+	if parts := strings.SplitN(first, ": ", 2); len(parts) == 2 && !strings.Contains(parts[0], " ") {
+		m.Type, m.Message = parts[0], parts[1]
+	} else if !strings.Contains(first, " ") {
+		m.Type, m.Message = first, ""
+	}
+	return m
+}
+
+// WriteJUnitXML writes the result as a JUnit XML report, with a test
+// suite per test class. A run error is reported as an errored test case
+// named after the runner.
+func (r *InstrumentResult) WriteJUnitXML(w io.Writer) error {
+	report := junitTestSuites{Name: r.Runner, Time: junitSeconds(r.Duration)}
+	suites := map[string]*junitTestSuite{}
+	var order []string
+	suiteTimes := map[string]time.Duration{}
+
+	for _, t := range r.Tests {
+		suite := suites[t.Test.Class]
+		if suite == nil {
+			suite = &junitTestSuite{Name: t.Test.Class}
+			suites[t.Test.Class] = suite
+			order = append(order, t.Test.Class)
+		}
+		tc := junitTestCase{ClassName: t.Test.Class, Name: t.Test.Method, Time: junitSeconds(t.Duration)}
+		if t.Device != "" {
+			tc.SystemOut = "device: " + t.Device
+		}
+		switch t.Status {
+		case TestFailed:
+			tc.Failure = stackMessage(t.Stack)
+			suite.Failures++
+		case TestIgnored:
+			tc.Skipped = &junitMessage{}
+			suite.Skipped++
+		case TestAssumptionFailure:
+			tc.Skipped = stackMessage(t.Stack)
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+		suiteTimes[t.Test.Class] += t.Duration
+	}
+	if r.RunError != "" {
+		suite := &junitTestSuite{Name: r.Runner, Tests: 1, Errors: 1, Time: junitSeconds(0)}
+		suite.Cases = []junitTestCase{{
+			ClassName: r.Runner,
+			Name:      "run",
+			Time:      junitSeconds(0),
+			Error:     &junitMessage{Message: r.RunError},
+		}}
+		suites[r.Runner] = suite
+		order = append(order, r.Runner)
+	}
+
+	for _, name := range order {
+		suite := suites[name]
+		if suite.Time == "" {
+			suite.Time = junitSeconds(suiteTimes[name])
+		}
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+		report.Suites = append(report.Suites, *suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
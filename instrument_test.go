@@ -0,0 +1,227 @@
+package gadb
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const instrumentOutput = `INSTRUMENTATION_STATUS: class=com.example.FooTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stream=
+com.example.FooTest:
+INSTRUMENTATION_STATUS: test=testPass
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.FooTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stream=.
+INSTRUMENTATION_STATUS: test=testPass
+INSTRUMENTATION_STATUS_CODE: 0
+INSTRUMENTATION_STATUS: class=com.example.FooTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: test=testFail
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.FooTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stack=java.lang.AssertionError: expected:<1> but was:<2>
+	at org.junit.Assert.fail(Assert.java:89)
+	at com.example.FooTest.testFail(FooTest.java:20)
+
+INSTRUMENTATION_STATUS: stream=
+Error in testFail(com.example.FooTest):
+java.lang.AssertionError: expected:<1> but was:<2>
+INSTRUMENTATION_STATUS: test=testFail
+INSTRUMENTATION_STATUS_CODE: -2
+INSTRUMENTATION_STATUS: class=com.example.BarTest
+INSTRUMENTATION_STATUS: current=3
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: test=testIgnored
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.BarTest
+INSTRUMENTATION_STATUS: current=3
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: test=testIgnored
+INSTRUMENTATION_STATUS_CODE: -3
+INSTRUMENTATION_STATUS: class=com.example.BarTest
+INSTRUMENTATION_STATUS: current=4
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: test=testAssume
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.BarTest
+INSTRUMENTATION_STATUS: current=4
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stack=org.junit.AssumptionViolatedException: got: <false>
+INSTRUMENTATION_STATUS: test=testAssume
+INSTRUMENTATION_STATUS_CODE: -4
+INSTRUMENTATION_RESULT: stream=
+
+Time: 0.123
+
+FAILURES!!!
+Tests run: 3,  Failures: 1
+
+INSTRUMENTATION_CODE: -1
+`
+
+func parseInstrumentOutput(output string) ([]TestEvent, *instrumentParser) {
+	p := newInstrumentParser("emulator-5554")
+	now := time.Unix(0, 0)
+	var events []TestEvent
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		now = now.Add(time.Second)
+		events = append(events, p.feed(line, now)...)
+	}
+	events = append(events, p.finish(now)...)
+	return events, p
+}
+
+func Test_instrumentParser(t *testing.T) {
+	events, p := parseInstrumentOutput(instrumentOutput)
+
+	type summary struct {
+		Test   string
+		Status TestStatus
+	}
+	var got []summary
+	for _, ev := range events {
+		got = append(got, summary{ev.Test.String(), ev.Status})
+	}
+	want := []summary{
+		{"com.example.FooTest#testPass", TestStarted},
+		{"com.example.FooTest#testPass", TestPassed},
+		{"com.example.FooTest#testFail", TestStarted},
+		{"com.example.FooTest#testFail", TestFailed},
+		{"com.example.BarTest#testIgnored", TestStarted},
+		{"com.example.BarTest#testIgnored", TestIgnored},
+		{"com.example.BarTest#testAssume", TestStarted},
+		{"com.example.BarTest#testAssume", TestAssumptionFailure},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v\nwant %v", got, want)
+	}
+
+	fail := events[3]
+	wantStack := "java.lang.AssertionError: expected:<1> but was:<2>\n" +
+		"\tat org.junit.Assert.fail(Assert.java:89)\n" +
+		"\tat com.example.FooTest.testFail(FooTest.java:20)\n"
+	if fail.Stack != wantStack {
+		t.Errorf("stack = %q, want %q", fail.Stack, wantStack)
+	}
+	if fail.Current != 2 || fail.Total != 4 || fail.Duration != 12*time.Second {
+		t.Errorf("fail event = %+v", fail)
+	}
+
+	result := p.result
+	if result.RunError != "" || result.Code != -1 || len(result.Tests) != 4 {
+		t.Errorf("result = %+v", result)
+	}
+	if !strings.Contains(result.Values["stream"], "Tests run: 3,  Failures: 1") {
+		t.Errorf("result stream = %q", result.Values["stream"])
+	}
+	if result.Passed() || len(result.Failed()) != 1 || result.Tests[0].Device != "emulator-5554" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func Test_instrumentParser_crash(t *testing.T) {
+	output := `INSTRUMENTATION_STATUS: class=com.example.FooTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: test=testCrash
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_RESULT: shortMsg=Process crashed.
+INSTRUMENTATION_CODE: 0
+`
+	events, p := parseInstrumentOutput(output)
+	if len(events) != 2 || events[1].Status != TestFailed || events[1].Stack != "Process crashed." {
+		t.Fatalf("events = %+v", events)
+	}
+	if p.result.RunError != "Process crashed." {
+		t.Errorf("RunError = %q", p.result.RunError)
+	}
+
+	_, p = parseInstrumentOutput("INSTRUMENTATION_FAILED: com.example.test/androidx.test.runner.AndroidJUnitRunner\nINSTRUMENTATION_CODE: 0\n")
+	if p.result.RunError != "com.example.test/androidx.test.runner.AndroidJUnitRunner" {
+		t.Errorf("RunError = %q", p.result.RunError)
+	}
+}
+
+func Test_instrumentParser_unmatchedFinish(t *testing.T) {
+	// testA never reports its result, and testB finishes without starting
+	output := `INSTRUMENTATION_STATUS: class=com.example.FooTest
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: test=testA
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.FooTest
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: test=testB
+INSTRUMENTATION_STATUS_CODE: 0
+INSTRUMENTATION_RESULT: stream=OK (2 tests)
+INSTRUMENTATION_CODE: -1
+`
+	events, p := parseInstrumentOutput(output)
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev.Test.Method+" "+string(ev.Status))
+	}
+	want := []string{"testA started", "testA failed", "testB passed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+	if p.running != nil || p.result.RunError != "" {
+		t.Errorf("running = %+v, RunError = %q", p.running, p.result.RunError)
+	}
+	if len(p.result.Tests) != 2 {
+		t.Errorf("tests = %+v", p.result.Tests)
+	}
+}
+
+func TestInstrumentOptions_args(t *testing.T) {
+	opts := InstrumentOptions{
+		Classes:           []string{"com.example.FooTest", "com.example.BarTest#testBaz"},
+		NumShards:         4,
+		ShardIndex:        1,
+		Coverage:          true,
+		CoverageFile:      "/sdcard/coverage.ec",
+		Args:              map[string]string{"debug": "false", "clearPackageData": "true"},
+		NoWindowAnimation: true,
+	}
+	want := "instrument -r -w --no-window-animation -e class 'com.example.FooTest,com.example.BarTest#testBaz' " +
+		"-e numShards 4 -e shardIndex 1 -e coverage true -e coverageFile /sdcard/coverage.ec " +
+		"-e clearPackageData true -e debug false"
+	if got := ShellJoin(opts.args()...); got != want {
+		t.Errorf("args = %s\nwant   %s", got, want)
+	}
+}
+
+func TestInstrumentResult_WriteJUnitXML(t *testing.T) {
+	_, p := parseInstrumentOutput(instrumentOutput)
+	p.result.Runner = "com.example.test/androidx.test.runner.AndroidJUnitRunner"
+	p.result.Duration = 1500 * time.Millisecond
+
+	var buf bytes.Buffer
+	if err := p.result.WriteJUnitXML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<testsuites name="com.example.test/androidx.test.runner.AndroidJUnitRunner" tests="4" failures="1" errors="0" skipped="2" time="1.500">`,
+		`<testsuite name="com.example.FooTest" tests="2" failures="1" errors="0" skipped="0" time="19.000">`,
+		`<testcase classname="com.example.FooTest" name="testPass" time="7.000">`,
+		`<failure message="expected:&lt;1&gt; but was:&lt;2&gt;" type="java.lang.AssertionError">`,
+		`<skipped message="got: &lt;false&gt;" type="org.junit.AssumptionViolatedException">`,
+		`<system-out>device: emulator-5554</system-out>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("JUnit XML lacks %s:\n%s", want, out)
+		}
+	}
+}
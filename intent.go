@@ -0,0 +1,337 @@
+package gadb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Intent flags, see android.content.Intent.
+const (
+	FlagIncludeStoppedPackages = 0x00000020
+	FlagActivityClearTask      = 0x00008000
+	FlagActivityClearTop       = 0x04000000
+	FlagActivityNewTask        = 0x10000000
+	FlagActivitySingleTop      = 0x20000000
+	FlagActivityNoHistory      = 0x40000000
+	FlagReceiverForeground     = 0x10000000
+)
+
+// Intent describes an intent for the activity manager. The zero value is
+// an empty intent; the Set, Add and Put methods return the intent so calls
+// can be chained:
+//
+//	intent := gadb.NewIntent("android.intent.action.VIEW").
+//		SetData("https://example.com/a b").
+//		PutString("title", "hello world")
+type Intent struct {
+	Action     string
+	Data       string
+	MimeType   string
+	Categories []string
+	// Component is the explicit target, e.g. "com.example/.MainActivity".
+	Component string
+	// Package restricts an implicit intent to one package.
+	Package string
+	Flags   uint32
+
+	extras []string
+}
+
+// NewIntent returns an intent with the given action.
+func NewIntent(action string) *Intent {
+	return &Intent{Action: action}
+}
+
+// SetData sets the intent's data URI.
+func (i *Intent) SetData(uri string) *Intent {
+	i.Data = uri
+	return i
+}
+
+// SetType sets the intent's MIME type.
+func (i *Intent) SetType(mimeType string) *Intent {
+	i.MimeType = mimeType
+	return i
+}
+
+// AddCategory adds a category.
+func (i *Intent) AddCategory(category string) *Intent {
+	i.Categories = append(i.Categories, category)
+	return i
+}
+
+// SetComponent sets the explicit target component.
+func (i *Intent) SetComponent(component string) *Intent {
+	i.Component = component
+	return i
+}
+
+// SetPackage restricts the intent to a package.
+func (i *Intent) SetPackage(pkg string) *Intent {
+	i.Package = pkg
+	return i
+}
+
+// AddFlags sets the given flags in addition to the existing ones.
+func (i *Intent) AddFlags(flags uint32) *Intent {
+	i.Flags |= flags
+	return i
+}
+
+// PutString adds a string extra.
+func (i *Intent) PutString(key, value string) *Intent {
+	return i.putExtra("--es", key, value)
+}
+
+// PutInt adds an int extra.
+func (i *Intent) PutInt(key string, value int32) *Intent {
+	return i.putExtra("--ei", key, strconv.FormatInt(int64(value), 10))
+}
+
+// PutLong adds a long extra.
+func (i *Intent) PutLong(key string, value int64) *Intent {
+	return i.putExtra("--el", key, strconv.FormatInt(value, 10))
+}
+
+// PutBool adds a boolean extra.
+func (i *Intent) PutBool(key string, value bool) *Intent {
+	return i.putExtra("--ez", key, strconv.FormatBool(value))
+}
+
+// PutFloat adds a float extra.
+func (i *Intent) PutFloat(key string, value float32) *Intent {
+	return i.putExtra("--ef", key, strconv.FormatFloat(float64(value), 'g', -1, 32))
+}
+
+// PutStringArray adds a string array extra.
+func (i *Intent) PutStringArray(key string, values ...string) *Intent {
+	escaped := make([]string, len(values))
+	for j, v := range values {
+		// am splits the array on unescaped commas
+		escaped[j] = strings.Replace(v, ",", `\,`, -1)
+	}
+	return i.putExtra("--esa", key, strings.Join(escaped, ","))
+}
+
+// PutURI adds a Uri extra.
+func (i *Intent) PutURI(key, uri string) *Intent {
+	return i.putExtra("--eu", key, uri)
+}
+
+func (i *Intent) putExtra(flag, key, value string) *Intent {
+	i.extras = append(i.extras, flag, key, value)
+	return i
+}
+
+// args renders the intent as am arguments, unquoted.
+func (i *Intent) args() []string {
+	var args []string
+	if i.Action != "" {
+		args = append(args, "-a", i.Action)
+	}
+	if i.Data != "" {
+		args = append(args, "-d", i.Data)
+	}
+	if i.MimeType != "" {
+		args = append(args, "-t", i.MimeType)
+	}
+	for _, category := range i.Categories {
+		args = append(args, "-c", category)
+	}
+	if i.Flags != 0 {
+		// am parses -f with Integer.decode, which rejects hex values with
+		// the sign bit set, so pass the flags as a signed int
+		args = append(args, "-f", strconv.Itoa(int(int32(i.Flags))))
+	}
+	args = append(args, i.extras...)
+	switch {
+	case i.Component != "":
+		args = append(args, "-n", i.Component)
+	case i.Package != "":
+		args = append(args, "-p", i.Package)
+	}
+	return args
+}
+
+// ActivityOptions configures StartActivity.
+type ActivityOptions struct {
+	// Wait waits for the launch to complete (-W) and reports its timing.
+	Wait bool
+	// ForceStop force-stops the target app before starting it (-S).
+	ForceStop bool
+	// User starts the activity as the given user id or "current" (--user).
+	User string
+}
+
+// ActivityResult is the outcome of StartActivity.
+type ActivityResult struct {
+	// Status is "ok" on success when waiting for the launch, "timeout" if
+	// the launch did not complete in time, or empty if not waiting.
+	Status string
+	// Activity is the component that was launched, when waiting.
+	Activity string
+	// LaunchState is one of "COLD", "WARM", "HOT" or "UNKNOWN (0)" on
+	// releases reporting it.
+	LaunchState string
+	ThisTime    time.Duration
+	TotalTime   time.Duration
+	WaitTime    time.Duration
+	// Warning holds a warning from am, such as the activity having been
+	// brought to the front instead of started.
+	Warning string
+}
+
+// StartActivity starts the activity matching intent.
+func (d Device) StartActivity(intent *Intent, opts ...ActivityOptions) (ActivityResult, error) {
+	if len(opts) == 0 {
+		opts = []ActivityOptions{{}}
+	}
+	args := []string{"start"}
+	if opts[0].Wait {
+		args = append(args, "-W")
+	}
+	if opts[0].ForceStop {
+		args = append(args, "-S")
+	}
+	if opts[0].User != "" {
+		args = append(args, "--user", opts[0].User)
+	}
+	out, err := d.am(append(args, intent.args()...)...)
+	if err != nil {
+		return ActivityResult{}, err
+	}
+	return parseStartActivity(out)
+}
+
+// StartService starts the service matching intent; with foreground set, as
+// a foreground service (start-foreground-service).
+func (d Device) StartService(intent *Intent, foreground ...bool) error {
+	command := "start-service"
+	if len(foreground) != 0 && foreground[0] {
+		command = "start-foreground-service"
+	}
+	out, err := d.am(append([]string{command}, intent.args()...)...)
+	if err != nil {
+		return err
+	}
+	return amError(out)
+}
+
+// BroadcastOptions configures Broadcast.
+type BroadcastOptions struct {
+	// User sends the broadcast to the given user id, "all" or "current" (--user).
+	User string
+	// ReceiverPermission requires receivers to hold the permission
+	// (--receiver-permission).
+	ReceiverPermission string
+}
+
+// BroadcastResult is the result of an ordered broadcast as set by its
+// receivers.
+type BroadcastResult struct {
+	Code int
+	// Data is the result data, or empty if none was set.
+	Data string
+	// Extras holds the result extras as printed by am, e.g.
+	// "Bundle[{key=value}]", or empty if none were set.
+	Extras string
+}
+
+// Broadcast sends intent as an ordered broadcast and waits for its result.
+func (d Device) Broadcast(intent *Intent, opts ...BroadcastOptions) (BroadcastResult, error) {
+	if len(opts) == 0 {
+		opts = []BroadcastOptions{{}}
+	}
+	args := []string{"broadcast"}
+	if opts[0].User != "" {
+		args = append(args, "--user", opts[0].User)
+	}
+	if opts[0].ReceiverPermission != "" {
+		args = append(args, "--receiver-permission", opts[0].ReceiverPermission)
+	}
+	out, err := d.am(append(args, intent.args()...)...)
+	if err != nil {
+		return BroadcastResult{}, err
+	}
+	return parseBroadcast(out)
+}
+
+// am runs an activity manager command through the shell, quoting args.
+func (d Device) am(args ...string) (string, error) {
+	return d.RunShellCommand("am", ShellJoin(args...))
+}
+
+// amError returns the error am reported in output, if any.
+func amError(output string) error {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Error: ") || strings.HasPrefix(line, "Exception occurred") {
+			return fmt.Errorf("am: %s", strings.TrimPrefix(line, "Error: "))
+		}
+	}
+	return nil
+}
+
+// parseStartActivity parses the output of `am start`, e.g.
+//
+//	Starting: Intent { act=android.intent.action.MAIN cmp=com.example/.Main }
+//	Status: ok
+//	LaunchState: COLD
+//	Activity: com.example/.Main
+//	TotalTime: 512
+//	WaitTime: 515
+//	Complete
+func parseStartActivity(output string) (ActivityResult, error) {
+	if err := amError(output); err != nil {
+		return ActivityResult{}, err
+	}
+	var result ActivityResult
+	millis := func(s string) time.Duration {
+		n, _ := strconv.Atoi(s)
+		return time.Duration(n) * time.Millisecond
+	}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "Status":
+			result.Status = parts[1]
+		case "Activity":
+			result.Activity = parts[1]
+		case "LaunchState":
+			result.LaunchState = parts[1]
+		case "ThisTime":
+			result.ThisTime = millis(parts[1])
+		case "TotalTime":
+			result.TotalTime = millis(parts[1])
+		case "WaitTime":
+			result.WaitTime = millis(parts[1])
+		case "Warning":
+			result.Warning = parts[1]
+		}
+	}
+	return result, nil
+}
+
+var broadcastCompleted = regexp.MustCompile(`(?m)^Broadcast completed: result=(-?\d+)(?:, data="(.*?)")?(?:, extras: (.*?))?\r?$`)
+
+// parseBroadcast parses the output of `am broadcast`, e.g.
+//
+//	Broadcasting: Intent { act=com.example.PING flg=0x400000 }
+//	Broadcast completed: result=-1, data="pong"
+func parseBroadcast(output string) (BroadcastResult, error) {
+	if err := amError(output); err != nil {
+		return BroadcastResult{}, err
+	}
+	m := broadcastCompleted.FindStringSubmatch(output)
+	if m == nil {
+		return BroadcastResult{}, fmt.Errorf("am broadcast: %s", strings.TrimSpace(output))
+	}
+	code, _ := strconv.Atoi(m[1])
+	return BroadcastResult{Code: code, Data: m[2], Extras: m[3]}, nil
+}
@@ -0,0 +1,93 @@
+package gadb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIntent_args(t *testing.T) {
+	intent := NewIntent("android.intent.action.VIEW").
+		SetData("https://example.com/a b?x=1&y=2").
+		AddCategory("android.intent.category.BROWSABLE").
+		SetComponent("com.example/.Main").
+		AddFlags(FlagActivityNewTask|FlagActivityClearTop).
+		PutString("title", "it's a test").
+		PutInt("count", -3).
+		PutLong("id", 1<<40).
+		PutBool("debug", true).
+		PutFloat("ratio", 0.5).
+		PutStringArray("tags", "a,b", "c").
+		PutURI("link", "content://x/y")
+
+	want := `-a android.intent.action.VIEW -d 'https://example.com/a b?x=1&y=2' ` +
+		`-c android.intent.category.BROWSABLE -f 335544320 ` +
+		`--es title 'it'\''s a test' --ei count -3 --el id 1099511627776 --ez debug true ` +
+		`--ef ratio 0.5 --esa tags 'a\,b,c' --eu link content://x/y -n com.example/.Main`
+	if got := ShellJoin(intent.args()...); got != want {
+		t.Errorf("args = %s\nwant   %s", got, want)
+	}
+
+	// flags with the sign bit set, beyond what am decodes as hex
+	highBit := (&Intent{}).AddFlags(0x80000000 | FlagActivityNewTask)
+	if got, want := ShellJoin(highBit.args()...), "-f -1879048192"; got != want {
+		t.Errorf("args = %s, want %s", got, want)
+	}
+}
+
+func Test_parseStartActivity(t *testing.T) {
+	output := `Starting: Intent { act=android.intent.action.MAIN cmp=com.example/.Main }
+Status: ok
+LaunchState: COLD
+Activity: com.example/.Main
+TotalTime: 512
+WaitTime: 515
+Complete
+`
+	want := ActivityResult{
+		Status:      "ok",
+		Activity:    "com.example/.Main",
+		LaunchState: "COLD",
+		TotalTime:   512 * time.Millisecond,
+		WaitTime:    515 * time.Millisecond,
+	}
+	got, err := parseStartActivity(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseStartActivity() = %+v, want %+v", got, want)
+	}
+
+	output = `Starting: Intent { cmp=com.example/.Missing }
+Error type 3
+Error: Activity class {com.example/com.example.Missing} does not exist.
+`
+	if _, err = parseStartActivity(output); err == nil {
+		t.Error("parseStartActivity accepted an error")
+	}
+}
+
+func Test_parseBroadcast(t *testing.T) {
+	tests := []struct {
+		output string
+		want   BroadcastResult
+	}{
+		{"Broadcasting: Intent { act=a flg=0x400000 }\nBroadcast completed: result=0\n", BroadcastResult{}},
+		{"Broadcast completed: result=-1, data=\"say \"hi\"\"\n", BroadcastResult{Code: -1, Data: `say "hi"`}},
+		{"Broadcast completed: result=1, data=\"x\", extras: Bundle[{k=v}]\r\n", BroadcastResult{Code: 1, Data: "x", Extras: "Bundle[{k=v}]"}},
+	}
+	for _, tt := range tests {
+		got, err := parseBroadcast(tt.output)
+		if err != nil {
+			t.Errorf("parseBroadcast(%q): %v", tt.output, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBroadcast(%q) = %+v, want %+v", tt.output, got, tt.want)
+		}
+	}
+	if _, err := parseBroadcast("Error: bad\n"); err == nil {
+		t.Error("parseBroadcast accepted an error")
+	}
+}
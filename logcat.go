@@ -0,0 +1,601 @@
+package gadb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogPriority is the priority (level) of a log entry.
+type LogPriority int
+
+const (
+	LogPriorityUnknown LogPriority = iota
+	LogPriorityDefault
+	LogPriorityVerbose
+	LogPriorityDebug
+	LogPriorityInfo
+	LogPriorityWarn
+	LogPriorityError
+	LogPriorityFatal
+	LogPrioritySilent
+)
+
+const logPriorityLetters = "??VDIWEFS"
+
+// String returns the single-letter form used by logcat, e.g. "E".
+func (p LogPriority) String() string {
+	if p < 0 || int(p) >= len(logPriorityLetters) {
+		return "?"
+	}
+	return logPriorityLetters[p : p+1]
+}
+
+func parseLogPriority(letter string) LogPriority {
+	if i := strings.Index(logPriorityLetters, letter); letter != "" && i >= 0 {
+		return LogPriority(i)
+	}
+	return LogPriorityUnknown
+}
+
+// LogBuffer names a logcat ring buffer.
+type LogBuffer string
+
+const (
+	LogBufferMain     LogBuffer = "main"
+	LogBufferRadio    LogBuffer = "radio"
+	LogBufferEvents   LogBuffer = "events"
+	LogBufferSystem   LogBuffer = "system"
+	LogBufferCrash    LogBuffer = "crash"
+	LogBufferStats    LogBuffer = "stats"
+	LogBufferSecurity LogBuffer = "security"
+	LogBufferKernel   LogBuffer = "kernel"
+
+	// LogBufferAll selects every buffer in LogcatOptions.Buffers.
+	LogBufferAll LogBuffer = "all"
+)
+
+// logBufferIDs maps the log_id_t values found in binary entries to names.
+var logBufferIDs = []LogBuffer{
+	LogBufferMain, LogBufferRadio, LogBufferEvents, LogBufferSystem,
+	LogBufferCrash, LogBufferStats, LogBufferSecurity, LogBufferKernel,
+}
+
+// isBinary reports whether entries of the buffer carry a binary payload
+// rather than a tag and message.
+func (b LogBuffer) isBinary() bool {
+	return b == LogBufferEvents || b == LogBufferStats || b == LogBufferSecurity
+}
+
+// LogEntry is a single parsed log record.
+type LogEntry struct {
+	Time time.Time
+	PID  int
+	TID  int
+
+	// UID is the sender's uid, or -1 when the device does not report it.
+	UID      int
+	Priority LogPriority
+	Tag      string
+	// Message may span several lines.
+	Message string
+	// Buffer is empty when the buffer could not be determined.
+	Buffer LogBuffer
+
+	// Payload holds the raw payload of entries from binary buffers such as
+	// events, whose Priority, Tag and Message are left empty.
+	Payload []byte
+}
+
+// LogcatFormat selects the output format requested from logcat.
+type LogcatFormat string
+
+const (
+	// LogcatFormatBinary is the binary logger_entry format (`logcat -B`).
+	LogcatFormatBinary LogcatFormat = "binary"
+	// LogcatFormatThreadtime is the `-v threadtime` text format.
+	LogcatFormatThreadtime LogcatFormat = "threadtime"
+
+	LogcatFormatBrief   LogcatFormat = "brief"
+	LogcatFormatLong    LogcatFormat = "long"
+	LogcatFormatProcess LogcatFormat = "process"
+	LogcatFormatRaw     LogcatFormat = "raw"
+	LogcatFormatTag     LogcatFormat = "tag"
+	LogcatFormatThread  LogcatFormat = "thread"
+	LogcatFormatTime    LogcatFormat = "time"
+)
+
+// LogFilter is a tag:priority filter spec. Entries with Tag below Priority
+// are dropped; Tag "*" applies to every tag.
+type LogFilter struct {
+	Tag      string
+	Priority LogPriority
+}
+
+// String returns the filter in logcat's "tag:P" form.
+func (f LogFilter) String() string {
+	return f.Tag + ":" + f.Priority.String()
+}
+
+// LogcatOptions configures which log entries are read and how.
+type LogcatOptions struct {
+	// Buffers selects the ring buffers to read (-b). When empty, logcat
+	// reads the device's default buffers.
+	Buffers []LogBuffer
+
+	// Filters are applied in order. To see only the listed tags, end with
+	// LogFilter{Tag: "*", Priority: LogPrioritySilent}.
+	Filters []LogFilter
+
+	// PID keeps only entries logged by the given process (--pid), if non-zero.
+	PID int
+	// UIDs keeps only entries logged by the given uids (--uid).
+	UIDs []int
+
+	// Since skips entries logged before the given time (-T), if non-zero.
+	Since time.Time
+	// Tail starts from the most recent Tail entries (-T count), if non-zero.
+	// Tail: 1 effectively follows the log from now on. logcat takes a single
+	// -T, so Tail is ignored when Since is set.
+	Tail int
+	// Dump prints the buffered log and exits instead of following it (-d).
+	Dump bool
+	// Regex keeps only entries whose message matches the expression (-e).
+	Regex string
+
+	// Format is the output format. Logcat defaults to the device's own
+	// default; LogcatEntries accepts only LogcatFormatBinary, the default,
+	// and LogcatFormatThreadtime.
+	Format LogcatFormat
+
+	// Reconnect makes Logcat wait for the device to come back and resume
+	// streaming when the connection drops, e.g. across a reboot.
+	// It has no effect when Dump is set.
+	//
+	// With the default, threadtime or time format, streaming resumes after
+	// the last entry written. The other formats carry no timestamp to resume
+	// from, so each reconnection writes the whole ring buffer again,
+	// repeating entries already written.
+	Reconnect bool
+}
+
+// selectArgs returns the arguments choosing which entries logcat reads,
+// excluding the output format.
+func (opts LogcatOptions) selectArgs() []string {
+	var args []string
+	for _, b := range opts.Buffers {
+		args = append(args, "-b", string(b))
+	}
+	if opts.PID != 0 {
+		args = append(args, fmt.Sprintf("--pid=%d", opts.PID))
+	}
+	if len(opts.UIDs) != 0 {
+		uids := make([]string, len(opts.UIDs))
+		for i, uid := range opts.UIDs {
+			uids[i] = strconv.Itoa(uid)
+		}
+		args = append(args, "--uid="+strings.Join(uids, ","))
+	}
+	if !opts.Since.IsZero() {
+		args = append(args, "-T", fmt.Sprintf("%d.%03d", opts.Since.Unix(), opts.Since.Nanosecond()/int(time.Millisecond)))
+	}
+	if opts.Tail != 0 && opts.Since.IsZero() {
+		args = append(args, "-T", strconv.Itoa(opts.Tail))
+	}
+	if opts.Dump {
+		args = append(args, "-d")
+	}
+	if opts.Regex != "" {
+		args = append(args, "-e", opts.Regex)
+	}
+	for _, f := range opts.Filters {
+		args = append(args, f.String())
+	}
+	return args
+}
+
+// command returns the logcat command line for the given format flags.
+func (opts LogcatOptions) command(formatArgs ...string) string {
+	return "logcat " + ShellJoin(append(formatArgs, opts.selectArgs()...)...)
+}
+
+// logcatReconnectInterval is how often Logcat polls for the device to come
+// back after the log stream ends.
+var logcatReconnectInterval = time.Second
+
+// Logcat copies the device log to dst as text until the log stream ends or
+// ctx is cancelled, and returns what ended it: nil when logcat exits (e.g.
+// with Dump), ctx.Err() on cancellation, or the read or write error.
+func (d Device) Logcat(ctx context.Context, dst io.Writer, opts ...LogcatOptions) error {
+	if len(opts) == 0 {
+		opts = []LogcatOptions{{}}
+	}
+	var formatArgs []string
+	if opts[0].Format != "" {
+		formatArgs = []string{"-v", string(opts[0].Format)}
+	}
+	cmd := "shell:" + opts[0].command(formatArgs...)
+	reconnect := opts[0].Reconnect && !opts[0].Dump
+
+	out := dst
+	var resume *logcatResumeWriter
+	switch opts[0].Format {
+	case "", LogcatFormatThreadtime, LogcatFormatTime:
+		if reconnect {
+			resume = &logcatResumeWriter{w: dst}
+			out = resume
+		}
+	}
+
+	for {
+		err := d.copyService(ctx, cmd, out)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var wErr *writeError
+		if !reconnect || errors.As(err, &wErr) {
+			if resume != nil && err == nil {
+				err = resume.flush()
+			}
+			return err
+		}
+		debugLog(fmt.Sprintf("logcat: stream of %s ended (%v), reconnecting", d.serial, err))
+		if err = d.waitForOnline(ctx, logcatReconnectInterval); err != nil {
+			return err
+		}
+		if resume != nil {
+			if resume.last != "" {
+				resumed := opts[0]
+				resumed.Since, resumed.Tail = time.Time{}, 0
+				cmd = "shell:" + resumed.command(append(formatArgs, "-T", resume.last)...)
+			}
+			resume.restart()
+		}
+	}
+}
+
+// logcatLineTime matches the timestamp starting the lines of the
+// threadtime and time formats, which -T accepts back.
+var logcatLineTime = regexp.MustCompile(`^(?:\d{4}-)?\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}`)
+
+// logcatResumeWriter forwards whole lines of timestamped logcat output and
+// remembers the last timestamp, so that a reconnected logcat can resume
+// with -T. As -T includes entries logged at that very time, the lines
+// already written with it are skipped after a restart.
+type logcatResumeWriter struct {
+	w       io.Writer
+	partial []byte
+	// last is the latest timestamp written, seen on count lines
+	last  string
+	count int
+	// skip lines with last's timestamp after a restart
+	skip int
+}
+
+func (rw *logcatResumeWriter) Write(p []byte) (int, error) {
+	rw.partial = append(rw.partial, p...)
+	var out []byte
+	for {
+		i := bytes.IndexByte(rw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := rw.partial[:i+1]
+		rw.partial = rw.partial[i+1:]
+
+		ts := logcatLineTime.Find(line)
+		if rw.skip > 0 && ts != nil && string(ts) == rw.last {
+			rw.skip--
+			continue
+		}
+		rw.skip = 0
+		if ts != nil {
+			if string(ts) == rw.last {
+				rw.count++
+			} else {
+				rw.last, rw.count = string(ts), 1
+			}
+		}
+		out = append(out, line...)
+	}
+	if len(out) != 0 {
+		if _, err := rw.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// restart prepares for the output of a logcat resumed from last, dropping
+// the line cut short by the disconnection.
+func (rw *logcatResumeWriter) restart() {
+	rw.partial = nil
+	rw.skip = rw.count
+}
+
+// flush writes out an unterminated last line.
+func (rw *logcatResumeWriter) flush() error {
+	if len(rw.partial) == 0 {
+		return nil
+	}
+	_, err := rw.w.Write(rw.partial)
+	rw.partial = nil
+	return err
+}
+
+// writeError marks a failure to write to the caller's destination, as
+// opposed to a failure reading from the device.
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string { return e.err.Error() }
+
+func (e *writeError) Unwrap() error { return e.err }
+
+// copyService copies the output of service to dst until it ends or ctx is
+// cancelled.
+func (d Device) copyService(ctx context.Context, service string, dst io.Writer) error {
+	tp, err := d.openService(service)
+	if err != nil {
+		return err
+	}
+	r := newCtxReadCloser(ctx, tp.sock)
+	defer func() { _ = r.Close() }()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, wErr := dst.Write(buf[:n]); wErr != nil {
+				return &writeError{err: wErr}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// waitForOnline waits, polling every interval, until the device is back
+// online. It always waits at least one interval so that a device that
+// keeps dropping connections is not hammered. As the device may have
+// rebooted meanwhile, its cached read-only properties are dropped.
+func (d Device) waitForOnline(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if state, err := d.State(); err == nil && state == StateOnline {
+			d.props.clear()
+			return nil
+		}
+	}
+}
+
+// Logcat2File appends the device log to file; see Logcat.
+func (d Device) Logcat2File(ctx context.Context, file string, opts ...LogcatOptions) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_SYNC|os.O_APPEND, 0755)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.Logcat(ctx, f, opts...)
+}
+
+// LogcatClear clears the buffers selected by opts, or the device's default
+// buffers when none are given.
+func (d Device) LogcatClear(opts ...LogcatOptions) error {
+	var buffers []string
+	if len(opts) != 0 {
+		for _, b := range opts[0].Buffers {
+			buffers = append(buffers, "-b", string(b))
+		}
+	}
+	_, err := d.executeCommand("shell:logcat " + ShellJoin(append(buffers, "-c")...))
+	return err
+}
+
+// LogcatStream delivers parsed log entries.
+type LogcatStream struct {
+	*streamState
+	entries chan LogEntry
+}
+
+// Entries returns the channel of log entries. It is closed when logcat
+// exits or the stream is cancelled, after which Err reports why.
+func (s *LogcatStream) Entries() <-chan LogEntry {
+	return s.entries
+}
+
+func (s *LogcatStream) emit(entry LogEntry) bool {
+	select {
+	case s.entries <- entry:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// LogcatEntries follows the device log and parses each record into a LogEntry.
+// Cancelling ctx, or calling Close, stops the stream.
+func (d Device) LogcatEntries(ctx context.Context, opts LogcatOptions) (*LogcatStream, error) {
+	var cmd string
+	read := readBinaryLog
+	switch opts.Format {
+	case "", LogcatFormatBinary:
+		cmd = opts.command("-B")
+	case LogcatFormatThreadtime:
+		cmd = opts.command("-v", "threadtime", "-v", "year", "-v", "UTC")
+		read = readTextLog
+	default:
+		return nil, fmt.Errorf("logcat: unsupported format for entries: %s", opts.Format)
+	}
+
+	s := &LogcatStream{streamState: newStreamState(ctx), entries: make(chan LogEntry)}
+	r, err := d.ExecOutReader(s.ctx, cmd)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.closeOnDone(r)
+	go func() {
+		defer close(s.entries)
+		s.finish(read(r, s.emit))
+	}()
+	return s, nil
+}
+
+// readBinaryLog parses logger_entry records (versions 1 to 4) until EOF or
+// until emit returns false.
+func readBinaryLog(r io.Reader, emit func(LogEntry) bool) error {
+	br := bufio.NewReaderSize(r, 16*1024)
+	for {
+		var prefix [4]byte
+		if _, err := io.ReadFull(br, prefix[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("logcat: read entry header: %w", err)
+		}
+		payloadLen := int(binary.LittleEndian.Uint16(prefix[0:]))
+		headerLen := int(binary.LittleEndian.Uint16(prefix[2:]))
+		if headerLen == 0 {
+			// v1 entries have padding instead of a header size
+			headerLen = 20
+		}
+		if headerLen < 20 {
+			return fmt.Errorf("logcat: invalid entry header size %d", headerLen)
+		}
+
+		raw := make([]byte, headerLen-4+payloadLen)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return fmt.Errorf("logcat: read entry: %w", err)
+		}
+		header, payload := raw[:headerLen-4], raw[headerLen-4:]
+
+		entry := LogEntry{
+			PID: int(int32(binary.LittleEndian.Uint32(header[0:]))),
+			TID: int(binary.LittleEndian.Uint32(header[4:])),
+			Time: time.Unix(
+				int64(binary.LittleEndian.Uint32(header[8:])),
+				int64(binary.LittleEndian.Uint32(header[12:])),
+			).UTC(),
+			UID: -1,
+		}
+		if headerLen >= 24 {
+			// v2 stored the euid here; every device still using it predates
+			// the binary format being useful, so treat it as v3's lid.
+			if lid := int(binary.LittleEndian.Uint32(header[16:])); lid < len(logBufferIDs) {
+				entry.Buffer = logBufferIDs[lid]
+			}
+		}
+		if headerLen >= 28 {
+			entry.UID = int(binary.LittleEndian.Uint32(header[20:]))
+		}
+
+		if entry.Buffer.isBinary() {
+			entry.Payload = payload
+		} else if len(payload) > 0 {
+			entry.Priority = LogPriority(payload[0])
+			fields := bytes.SplitN(payload[1:], []byte{0}, 2)
+			entry.Tag = string(fields[0])
+			if len(fields) > 1 {
+				entry.Message = strings.TrimRight(string(fields[1]), "\x00\n")
+			}
+		}
+
+		if !emit(entry) {
+			return nil
+		}
+	}
+}
+
+// threadtimeLine matches `-v threadtime -v year` output, e.g.
+// "2024-03-14 08:15:30.123  1234  1260 I ActivityManager: Start proc".
+var threadtimeLine = regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d+)\s+(\d+)\s+(\d+)\s+([VDIWEFS])\s+(.*?)\s*: ?(.*)$`)
+
+const logDividerPrefix = "--------- beginning of "
+
+// readTextLog parses threadtime output. logcat prints each line of a
+// multi-line message with a repeated header; consecutive lines sharing a
+// header are merged back into one entry.
+func readTextLog(r io.Reader, emit func(LogEntry) bool) error {
+	br := bufio.NewReaderSize(r, 16*1024)
+
+	var pending *LogEntry
+	var pendingHeader string
+	var buffer LogBuffer
+	flush := func() bool {
+		if pending == nil {
+			return true
+		}
+		entry := *pending
+		pending = nil
+		return emit(entry)
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if line = trimLineEnd(line); line != "" {
+			if strings.HasPrefix(line, logDividerPrefix) {
+				if !flush() {
+					return nil
+				}
+				buffer = LogBuffer(strings.TrimPrefix(line, logDividerPrefix))
+			} else if m := threadtimeLine.FindStringSubmatch(line); m != nil {
+				header := line[:len(line)-len(m[6])]
+				if pending != nil && header == pendingHeader {
+					pending.Message += "\n" + m[6]
+				} else {
+					if !flush() {
+						return nil
+					}
+					pending, pendingHeader = parseThreadtime(m, buffer), header
+				}
+			}
+		}
+		if err == io.EOF {
+			flush()
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("logcat: %w", err)
+		}
+		// Do not hold the last entry back while waiting for more output.
+		if br.Buffered() == 0 && !flush() {
+			return nil
+		}
+	}
+}
+
+func parseThreadtime(m []string, buffer LogBuffer) *LogEntry {
+	t, _ := time.Parse("2006-01-02 15:04:05.000", m[1])
+	pid, _ := strconv.Atoi(m[2])
+	tid, _ := strconv.Atoi(m[3])
+	return &LogEntry{
+		Time:     t,
+		PID:      pid,
+		TID:      tid,
+		UID:      -1,
+		Priority: parseLogPriority(m[4]),
+		Tag:      m[5],
+		Message:  m[6],
+		Buffer:   buffer,
+	}
+}
@@ -0,0 +1,215 @@
+package gadb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// binaryLogEntry encodes a v4 logger_entry as written by `logcat -B`.
+func binaryLogEntry(pid, tid int32, t time.Time, lid, uid uint32, payload []byte) []byte {
+	var b bytes.Buffer
+	w := func(v interface{}) { _ = binary.Write(&b, binary.LittleEndian, v) }
+	w(uint16(len(payload)))
+	w(uint16(28))
+	w(pid)
+	w(uint32(tid))
+	w(uint32(t.Unix()))
+	w(uint32(t.Nanosecond()))
+	w(lid)
+	w(uid)
+	b.Write(payload)
+	return b.Bytes()
+}
+
+func collectEntries(t *testing.T, read func(func(LogEntry) bool) error) []LogEntry {
+	t.Helper()
+	var entries []LogEntry
+	if err := read(func(e LogEntry) bool {
+		entries = append(entries, e)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func Test_readBinaryLog(t *testing.T) {
+	ts := time.Date(2024, 3, 14, 8, 15, 30, 123000000, time.UTC)
+
+	var stream bytes.Buffer
+	stream.Write(binaryLogEntry(1234, 1260, ts, 0, 10123,
+		[]byte("\x06AndroidRuntime\x00FATAL EXCEPTION: main\nProcess: com.example, PID: 1234\n\x00")))
+	stream.Write(binaryLogEntry(500, 500, ts, 2, 1000, []byte{0x7d, 0x75, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}))
+
+	entries := collectEntries(t, func(emit func(LogEntry) bool) error {
+		return readBinaryLog(&stream, emit)
+	})
+
+	want := []LogEntry{
+		{
+			Time: ts, PID: 1234, TID: 1260, UID: 10123,
+			Priority: LogPriorityError, Tag: "AndroidRuntime",
+			Message: "FATAL EXCEPTION: main\nProcess: com.example, PID: 1234",
+			Buffer:  LogBufferMain,
+		},
+		{
+			Time: ts, PID: 500, TID: 500, UID: 1000,
+			Buffer:  LogBufferEvents,
+			Payload: []byte{0x7d, 0x75, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00},
+		},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got  %+v\nwant %+v", entries, want)
+	}
+}
+
+func Test_readTextLog(t *testing.T) {
+	text := strings.Join([]string{
+		"--------- beginning of crash",
+		"2024-03-14 08:15:30.123  1234  1260 E AndroidRuntime: FATAL EXCEPTION: main",
+		"2024-03-14 08:15:30.123  1234  1260 E AndroidRuntime: Process: com.example, PID: 1234",
+		"--------- beginning of main",
+		"2024-03-14 08:15:31.000   321   321 I ActivityManager: Start proc: a:b",
+		"2024-03-14 08:15:31.001   321   321 D Tag with space:",
+		"",
+	}, "\r\n")
+
+	entries := collectEntries(t, func(emit func(LogEntry) bool) error {
+		return readTextLog(strings.NewReader(text), emit)
+	})
+
+	want := []LogEntry{
+		{
+			Time: time.Date(2024, 3, 14, 8, 15, 30, 123000000, time.UTC), PID: 1234, TID: 1260, UID: -1,
+			Priority: LogPriorityError, Tag: "AndroidRuntime",
+			Message: "FATAL EXCEPTION: main\nProcess: com.example, PID: 1234",
+			Buffer:  LogBufferCrash,
+		},
+		{
+			Time: time.Date(2024, 3, 14, 8, 15, 31, 0, time.UTC), PID: 321, TID: 321, UID: -1,
+			Priority: LogPriorityInfo, Tag: "ActivityManager", Message: "Start proc: a:b",
+			Buffer: LogBufferMain,
+		},
+		{
+			Time: time.Date(2024, 3, 14, 8, 15, 31, 1000000, time.UTC), PID: 321, TID: 321, UID: -1,
+			Priority: LogPriorityDebug, Tag: "Tag with space",
+			Buffer: LogBufferMain,
+		},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got  %+v\nwant %+v", entries, want)
+	}
+}
+
+func TestLogcatOptions_command(t *testing.T) {
+	opts := LogcatOptions{
+		Buffers: []LogBuffer{LogBufferCrash, LogBufferMain},
+		Filters: []LogFilter{
+			{Tag: "ActivityManager", Priority: LogPriorityInfo},
+			{Tag: "*", Priority: LogPrioritySilent},
+		},
+		PID:   1234,
+		UIDs:  []int{1000, 10123},
+		Since: time.Unix(1710404130, 123456789),
+		Tail:  5, // ignored in favour of Since
+		Dump:  true,
+		Regex: "Start proc .*",
+	}
+	want := "logcat -v threadtime -b crash -b main '--pid=1234' '--uid=1000,10123' -T 1710404130.123 -d -e 'Start proc .*' ActivityManager:I '*:S'"
+	if got := opts.command("-v", "threadtime"); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestDevice_Logcat_reconnect(t *testing.T) {
+	defer func(interval time.Duration) { logcatReconnectInterval = interval }(logcatReconnectInterval)
+	logcatReconnectInterval = 10 * time.Millisecond
+
+	streams := []string{
+		// the connection drops in the middle of a line
+		"01-02 03:04:05.100  1  1 I A: one\r\n" +
+			"01-02 03:04:05.200  1  1 I A: two\r\n" +
+			"01-02 03:04:05.200  1  1 I A: three\r\n" +
+			"01-02 03:04:05.3",
+		// -T replays the entries logged at the resume time
+		"01-02 03:04:05.200  1  1 I A: two\r\n" +
+			"01-02 03:04:05.200  1  1 I A: three\r\n" +
+			"01-02 03:04:05.300  1  1 I A: four\r\n",
+	}
+	resumed := make(chan struct{})
+	f := &fakeADB{}
+	f.handle = func(service string, conn net.Conn) {
+		n := len(f.calls())
+		if n <= len(streams) {
+			_, _ = io.WriteString(conn, streams[n-1])
+			return
+		}
+		close(resumed)
+		_, _ = ioutil.ReadAll(conn)
+	}
+	d := newFakeADB(t, f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var dst bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- d.Logcat(ctx, &dst, LogcatOptions{Format: LogcatFormatThreadtime, Reconnect: true}) }()
+
+	select {
+	case <-resumed:
+	case err := <-done:
+		t.Fatalf("Logcat() returned early: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Logcat() did not reconnect")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Logcat() = %v, want context.Canceled", err)
+	}
+
+	want := "01-02 03:04:05.100  1  1 I A: one\r\n" +
+		"01-02 03:04:05.200  1  1 I A: two\r\n" +
+		"01-02 03:04:05.200  1  1 I A: three\r\n" +
+		"01-02 03:04:05.300  1  1 I A: four\r\n"
+	if dst.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", dst.String(), want)
+	}
+	wantCalls := []string{
+		"shell:logcat -v threadtime",
+		"shell:logcat -v threadtime -T '01-02 03:04:05.200'",
+		"shell:logcat -v threadtime -T '01-02 03:04:05.300'",
+	}
+	if got := f.calls(); !reflect.DeepEqual(got, wantCalls) {
+		t.Errorf("calls = %q, want %q", got, wantCalls)
+	}
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestDevice_Logcat_writeError(t *testing.T) {
+	f := &fakeADB{handle: func(service string, conn net.Conn) {
+		_, _ = io.WriteString(conn, "01-02 03:04:05.100  1  1 I A: one\n")
+	}}
+	d := newFakeADB(t, f)
+
+	errFull := errors.New("disk full")
+	err := d.Logcat(context.Background(), failingWriter{errFull}, LogcatOptions{Reconnect: true})
+	if !errors.Is(err, errFull) {
+		t.Errorf("Logcat() = %v, want %v", err, errFull)
+	}
+	// a failing destination is not a dropped connection
+	if calls := f.calls(); len(calls) != 1 {
+		t.Errorf("calls = %q, want a single logcat", calls)
+	}
+}
@@ -0,0 +1,669 @@
+package gadb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// H.264 NAL unit types.
+const (
+	nalSlice    = 1
+	nalIDRSlice = 5
+	nalSEI      = 6
+	nalSPS      = 7
+	nalPPS      = 8
+	nalAUD      = 9
+)
+
+// mp4Timescale is the media timescale, in units per second.
+const mp4Timescale = 90000
+
+// MP4Writer muxes an H.264 Annex B elementary stream, such as the one
+// written by ScreenRecord, into an MP4 file. Samples are timestamped by
+// the time their data is written, which for a live recording is when the
+// frame was captured. The stream must not contain B-frames, which
+// screenrecord does not produce.
+//
+// The media data is written as it arrives; Close writes the index (moov)
+// at the end of the file and seeks back to patch the mdat size.
+type MP4Writer struct {
+	w io.WriteSeeker
+	// now returns the arrival time of data, overridable in tests
+	now func() time.Time
+
+	mdatStart int64 // offset of the mdat box
+	offset    int64 // offset of the next sample
+
+	pending []byte    // Annex B data not yet split into NAL units
+	nalTime time.Time // arrival of the NAL unit starting pending
+	au      []byte    // the access unit being assembled, in AVCC form
+	auTime  time.Time
+	auSync  bool
+	auVCL   bool
+
+	sps, pps []byte
+	spsInfo  h264SPS
+
+	samples []mp4Sample
+	err     error
+	closed  bool
+}
+
+type mp4Sample struct {
+	offset int64
+	size   uint32
+	time   time.Time
+	sync   bool
+}
+
+// NewMP4Writer starts an MP4 file at the current position of w.
+func NewMP4Writer(w io.WriteSeeker) (*MP4Writer, error) {
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	var head bytes.Buffer
+	writeBox(&head, "ftyp", func(b *bytes.Buffer) {
+		b.WriteString("isom")
+		writeU32(b, 0x200)
+		b.WriteString("isomiso2avc1mp41")
+	})
+	m := &MP4Writer{w: w, now: time.Now, mdatStart: start + int64(head.Len())}
+	// a 64-bit mdat header, whose size is patched in Close
+	writeU32(&head, 1)
+	head.WriteString("mdat")
+	writeU64(&head, 0)
+	if _, err = w.Write(head.Bytes()); err != nil {
+		return nil, err
+	}
+	m.offset = start + int64(head.Len())
+	return m, nil
+}
+
+// Write adds H.264 Annex B data. It may split NAL units anywhere.
+func (m *MP4Writer) Write(p []byte) (int, error) {
+	if m.closed {
+		return 0, errors.New("mp4: write after close")
+	}
+	if m.err != nil {
+		return 0, m.err
+	}
+	now := m.now()
+	if len(m.pending) == 0 {
+		m.nalTime = now
+	}
+	m.pending = append(m.pending, p...)
+	// only NAL units followed by another start code are known to be complete
+	for {
+		start, n := nextStartCode(m.pending, 0)
+		if start < 0 {
+			// keep what may be the beginning of a start code
+			if len(m.pending) > 3 {
+				m.pending = m.pending[len(m.pending)-3:]
+			}
+			break
+		}
+		end, _ := nextStartCode(m.pending, start+n)
+		if end < 0 {
+			m.pending = m.pending[start:]
+			break
+		}
+		if err := m.addNAL(trimTrailingZeros(m.pending[start+n:end]), m.nalTime); err != nil {
+			m.err = err
+			return 0, err
+		}
+		m.pending = m.pending[end:]
+		m.nalTime = now
+	}
+	return len(p), nil
+}
+
+// Close flushes the last access unit and writes the index. It does not
+// close the underlying writer.
+func (m *MP4Writer) Close() error {
+	if m.closed {
+		return m.err
+	}
+	m.closed = true
+	if m.err != nil {
+		return m.err
+	}
+	if start, n := nextStartCode(m.pending, 0); start >= 0 {
+		if err := m.addNAL(trimTrailingZeros(m.pending[start+n:]), m.nalTime); err != nil {
+			return err
+		}
+	}
+	m.pending = nil
+	if err := m.flushAU(); err != nil {
+		return err
+	}
+	if m.sps == nil || m.pps == nil {
+		return errors.New("mp4: stream has no SPS and PPS")
+	}
+
+	var moov bytes.Buffer
+	m.writeMoov(&moov)
+	if _, err := m.w.Write(moov.Bytes()); err != nil {
+		return err
+	}
+	end, err := m.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = m.w.Seek(m.mdatStart+8, io.SeekStart); err != nil {
+		return err
+	}
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(m.offset-m.mdatStart))
+	if _, err = m.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err = m.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// nextStartCode finds the next 00 00 01 at or after from. It returns the
+// index and length of the start code, which includes the leading zero byte
+// of a 4-byte start code.
+func nextStartCode(b []byte, from int) (int, int) {
+	for i := from; i+3 <= len(b); i++ {
+		if b[i] == 0 && b[i+1] == 0 && b[i+2] == 1 {
+			if i > from && b[i-1] == 0 {
+				return i - 1, 4
+			}
+			return i, 3
+		}
+	}
+	return -1, 0
+}
+
+func trimTrailingZeros(nal []byte) []byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	return nal
+}
+
+// addNAL adds a complete NAL unit received at now.
+func (m *MP4Writer) addNAL(nal []byte, now time.Time) error {
+	if len(nal) == 0 {
+		return nil
+	}
+	typ := nal[0] & 0x1f
+
+	// a new access unit starts with an AUD, parameter sets or SEI after
+	// the current one's slices, or with the first slice of a new picture
+	// (first_mb_in_slice 0, coded as a single 1 bit)
+	switch typ {
+	case nalAUD, nalSPS, nalPPS, nalSEI:
+		if m.auVCL {
+			if err := m.flushAU(); err != nil {
+				return err
+			}
+		}
+	case nalSlice, nalIDRSlice:
+		if m.auVCL && len(nal) > 1 && nal[1]&0x80 != 0 {
+			if err := m.flushAU(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(m.au) == 0 && !m.auVCL {
+		m.auTime = now
+	}
+
+	switch typ {
+	case nalAUD:
+		return nil
+	case nalSPS:
+		if m.sps == nil {
+			info, err := parseSPS(nal)
+			if err != nil {
+				return err
+			}
+			m.sps, m.spsInfo = append([]byte(nil), nal...), info
+			return nil
+		}
+		if bytes.Equal(nal, m.sps) {
+			return nil
+		}
+	case nalPPS:
+		if m.pps == nil {
+			m.pps = append([]byte(nil), nal...)
+			return nil
+		}
+		if bytes.Equal(nal, m.pps) {
+			return nil
+		}
+	case nalIDRSlice:
+		m.auSync = true
+		m.auVCL = true
+	case nalSlice:
+		m.auVCL = true
+	}
+	// parameter sets differing from the first ones stay in-band
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(nal)))
+	m.au = append(append(m.au, length[:]...), nal...)
+	return nil
+}
+
+// flushAU writes the access unit assembled so far as a sample.
+func (m *MP4Writer) flushAU() error {
+	if !m.auVCL {
+		// SEI or parameter sets alone: keep them for the next picture
+		return nil
+	}
+	if _, err := m.w.Write(m.au); err != nil {
+		return err
+	}
+	m.samples = append(m.samples, mp4Sample{offset: m.offset, size: uint32(len(m.au)), time: m.auTime, sync: m.auSync})
+	m.offset += int64(len(m.au))
+	m.au, m.auSync, m.auVCL = m.au[:0], false, false
+	return nil
+}
+
+// durations returns the duration of each sample in mp4Timescale units.
+// The last sample gets the duration of the one before it.
+func (m *MP4Writer) durations() []uint32 {
+	durations := make([]uint32, len(m.samples))
+	for i := 0; i+1 < len(m.samples); i++ {
+		d := m.samples[i+1].time.Sub(m.samples[i].time)
+		ticks := d * mp4Timescale / time.Second
+		if ticks < 1 {
+			ticks = 1
+		}
+		durations[i] = uint32(ticks)
+	}
+	if n := len(durations); n > 1 {
+		durations[n-1] = durations[n-2]
+	} else if n == 1 {
+		durations[0] = mp4Timescale / 30
+	}
+	return durations
+}
+
+func (m *MP4Writer) writeMoov(buf *bytes.Buffer) {
+	durations := m.durations()
+	var total uint64
+	for _, d := range durations {
+		total += uint64(d)
+	}
+	movieDuration := uint32(total * 1000 / mp4Timescale)
+	width, height := uint32(m.spsInfo.width), uint32(m.spsInfo.height)
+
+	writeBox(buf, "moov", func(b *bytes.Buffer) {
+		writeFullBox(b, "mvhd", 0, 0, func(b *bytes.Buffer) {
+			writeU32(b, 0) // creation time
+			writeU32(b, 0) // modification time
+			writeU32(b, 1000)
+			writeU32(b, movieDuration)
+			writeU32(b, 0x00010000) // rate 1.0
+			writeU16(b, 0x0100)     // volume 1.0
+			b.Write(make([]byte, 10))
+			writeMatrix(b)
+			b.Write(make([]byte, 24))
+			writeU32(b, 2) // next track ID
+		})
+		writeBox(b, "trak", func(b *bytes.Buffer) {
+			// flags: track enabled and in movie
+			writeFullBox(b, "tkhd", 0, 3, func(b *bytes.Buffer) {
+				writeU32(b, 0)
+				writeU32(b, 0)
+				writeU32(b, 1) // track ID
+				writeU32(b, 0)
+				writeU32(b, movieDuration)
+				b.Write(make([]byte, 8))
+				writeU16(b, 0) // layer
+				writeU16(b, 0) // alternate group
+				writeU16(b, 0) // volume
+				writeU16(b, 0)
+				writeMatrix(b)
+				writeU32(b, width<<16)
+				writeU32(b, height<<16)
+			})
+			writeBox(b, "mdia", func(b *bytes.Buffer) {
+				writeFullBox(b, "mdhd", 0, 0, func(b *bytes.Buffer) {
+					writeU32(b, 0)
+					writeU32(b, 0)
+					writeU32(b, mp4Timescale)
+					writeU32(b, uint32(total))
+					writeU16(b, 0x55c4) // language "und"
+					writeU16(b, 0)
+				})
+				writeFullBox(b, "hdlr", 0, 0, func(b *bytes.Buffer) {
+					writeU32(b, 0)
+					b.WriteString("vide")
+					b.Write(make([]byte, 12))
+					b.WriteString("VideoHandler\x00")
+				})
+				writeBox(b, "minf", func(b *bytes.Buffer) {
+					writeFullBox(b, "vmhd", 0, 1, func(b *bytes.Buffer) {
+						b.Write(make([]byte, 8))
+					})
+					writeBox(b, "dinf", func(b *bytes.Buffer) {
+						writeFullBox(b, "dref", 0, 0, func(b *bytes.Buffer) {
+							writeU32(b, 1)
+							// flags 1: media data in the same file
+							writeFullBox(b, "url ", 0, 1, func(*bytes.Buffer) {})
+						})
+					})
+					writeBox(b, "stbl", func(b *bytes.Buffer) {
+						m.writeStbl(b, durations)
+					})
+				})
+			})
+		})
+	})
+}
+
+func (m *MP4Writer) writeStbl(b *bytes.Buffer, durations []uint32) {
+	info := m.spsInfo
+	writeFullBox(b, "stsd", 0, 0, func(b *bytes.Buffer) {
+		writeU32(b, 1)
+		writeBox(b, "avc1", func(b *bytes.Buffer) {
+			b.Write(make([]byte, 6))
+			writeU16(b, 1) // data reference index
+			b.Write(make([]byte, 16))
+			writeU16(b, uint16(info.width))
+			writeU16(b, uint16(info.height))
+			writeU32(b, 0x00480000) // 72 dpi
+			writeU32(b, 0x00480000)
+			writeU32(b, 0)
+			writeU16(b, 1) // frame count
+			b.Write(make([]byte, 32))
+			writeU16(b, 0x0018) // depth
+			writeU16(b, 0xffff)
+			writeBox(b, "avcC", func(b *bytes.Buffer) {
+				b.WriteByte(1)
+				b.Write(m.sps[1:4]) // profile, compatibility, level
+				b.WriteByte(0xff)   // 4-byte NAL lengths
+				b.WriteByte(0xe1)   // one SPS
+				writeU16(b, uint16(len(m.sps)))
+				b.Write(m.sps)
+				b.WriteByte(1)
+				writeU16(b, uint16(len(m.pps)))
+				b.Write(m.pps)
+				if info.highProfile {
+					b.WriteByte(0xfc | info.chromaFormat)
+					b.WriteByte(0xf8 | info.bitDepthLuma)
+					b.WriteByte(0xf8 | info.bitDepthChroma)
+					b.WriteByte(0)
+				}
+			})
+		})
+	})
+
+	writeFullBox(b, "stts", 0, 0, func(b *bytes.Buffer) {
+		type run struct{ count, delta uint32 }
+		var runs []run
+		for _, d := range durations {
+			if n := len(runs); n > 0 && runs[n-1].delta == d {
+				runs[n-1].count++
+			} else {
+				runs = append(runs, run{1, d})
+			}
+		}
+		writeU32(b, uint32(len(runs)))
+		for _, r := range runs {
+			writeU32(b, r.count)
+			writeU32(b, r.delta)
+		}
+	})
+
+	var sync []uint32
+	for i, s := range m.samples {
+		if s.sync {
+			sync = append(sync, uint32(i+1))
+		}
+	}
+	if len(sync) != len(m.samples) {
+		writeFullBox(b, "stss", 0, 0, func(b *bytes.Buffer) {
+			writeU32(b, uint32(len(sync)))
+			for _, n := range sync {
+				writeU32(b, n)
+			}
+		})
+	}
+
+	// one sample per chunk
+	writeFullBox(b, "stsc", 0, 0, func(b *bytes.Buffer) {
+		writeU32(b, 1)
+		writeU32(b, 1)
+		writeU32(b, 1)
+		writeU32(b, 1)
+	})
+	writeFullBox(b, "stsz", 0, 0, func(b *bytes.Buffer) {
+		writeU32(b, 0)
+		writeU32(b, uint32(len(m.samples)))
+		for _, s := range m.samples {
+			writeU32(b, s.size)
+		}
+	})
+	if m.offset > 0xffffffff {
+		writeFullBox(b, "co64", 0, 0, func(b *bytes.Buffer) {
+			writeU32(b, uint32(len(m.samples)))
+			for _, s := range m.samples {
+				writeU64(b, uint64(s.offset))
+			}
+		})
+	} else {
+		writeFullBox(b, "stco", 0, 0, func(b *bytes.Buffer) {
+			writeU32(b, uint32(len(m.samples)))
+			for _, s := range m.samples {
+				writeU32(b, uint32(s.offset))
+			}
+		})
+	}
+}
+
+func writeBox(buf *bytes.Buffer, typ string, body func(*bytes.Buffer)) {
+	var b bytes.Buffer
+	body(&b)
+	writeU32(buf, uint32(8+b.Len()))
+	buf.WriteString(typ)
+	buf.Write(b.Bytes())
+}
+
+func writeFullBox(buf *bytes.Buffer, typ string, version uint8, flags uint32, body func(*bytes.Buffer)) {
+	writeBox(buf, typ, func(b *bytes.Buffer) {
+		writeU32(b, uint32(version)<<24|flags)
+		body(b)
+	})
+}
+
+// writeMatrix writes the identity transformation matrix.
+func writeMatrix(b *bytes.Buffer) {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		writeU32(b, v)
+	}
+}
+
+func writeU16(b *bytes.Buffer, v uint16) {
+	var p [2]byte
+	binary.BigEndian.PutUint16(p[:], v)
+	b.Write(p[:])
+}
+
+func writeU32(b *bytes.Buffer, v uint32) {
+	var p [4]byte
+	binary.BigEndian.PutUint32(p[:], v)
+	b.Write(p[:])
+}
+
+func writeU64(b *bytes.Buffer, v uint64) {
+	var p [8]byte
+	binary.BigEndian.PutUint64(p[:], v)
+	b.Write(p[:])
+}
+
+// h264SPS holds the fields of a sequence parameter set the muxer needs.
+type h264SPS struct {
+	width, height int
+	// highProfile is set for the profiles whose avcC record carries the
+	// chroma format and bit depths
+	highProfile    bool
+	chromaFormat   uint8
+	bitDepthLuma   uint8 // minus 8
+	bitDepthChroma uint8 // minus 8
+}
+
+var errBadSPS = errors.New("mp4: malformed H.264 SPS")
+
+// parseSPS decodes the picture size from an SPS NAL unit (ITU-T H.264
+// section 7.3.2.1.1).
+func parseSPS(nal []byte) (sps h264SPS, err error) {
+	if len(nal) < 4 {
+		return sps, errBadSPS
+	}
+	r := &bitReader{data: unescapeRBSP(nal[4:])}
+	profile := nal[1]
+
+	r.ue() // seq_parameter_set_id
+	sps.chromaFormat = 1
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		sps.highProfile = true
+		sps.chromaFormat = uint8(r.ue())
+		if sps.chromaFormat == 3 {
+			r.bit() // separate_colour_plane_flag
+		}
+		sps.bitDepthLuma = uint8(r.ue())
+		sps.bitDepthChroma = uint8(r.ue())
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		if r.bit() == 1 {
+			lists := 8
+			if sps.chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bit() == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					skipScalingList(r, size)
+				}
+			}
+		}
+	}
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit() // delta_pic_order_always_zero_flag
+		r.se()  // offset_for_non_ref_pic
+		r.se()  // offset_for_top_to_bottom_field
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	widthMbs := int(r.ue()) + 1
+	heightMapUnits := int(r.ue()) + 1
+	frameMbsOnly := int(r.bit())
+	if frameMbsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+
+	sps.width = widthMbs * 16
+	sps.height = (2 - frameMbsOnly) * heightMapUnits * 16
+	if r.bit() == 1 {
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		cropX, cropY := 1, 2-frameMbsOnly
+		switch sps.chromaFormat {
+		case 1:
+			cropX, cropY = 2, 2*(2-frameMbsOnly)
+		case 2:
+			cropX = 2
+		}
+		sps.width -= cropX * (left + right)
+		sps.height -= cropY * (top + bottom)
+	}
+	if r.err != nil || sps.width <= 0 || sps.height <= 0 {
+		return h264SPS{}, errBadSPS
+	}
+	return sps, nil
+}
+
+func skipScalingList(r *bitReader, size int) {
+	last, next := 8, 8
+	for j := 0; j < size && r.err == nil; j++ {
+		if next != 0 {
+			next = (last + int(r.se()) + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// unescapeRBSP removes emulation prevention bytes (00 00 03).
+func unescapeRBSP(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// bitReader reads the bit fields of H.264 syntax structures. Reading past
+// the end sets err and yields zeros.
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *bitReader) bit() uint32 {
+	if r.pos >= len(r.data)*8 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := r.data[r.pos/8] >> (7 - uint(r.pos%8)) & 1
+	r.pos++
+	return uint32(b)
+}
+
+// ue reads an unsigned Exp-Golomb code.
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for r.bit() == 0 {
+		if r.err != nil || zeros == 31 {
+			r.err = fmt.Errorf("bad Exp-Golomb code: %w", errBadSPS)
+			return 0
+		}
+		zeros++
+	}
+	v := uint32(0)
+	for i := 0; i < zeros; i++ {
+		v = v<<1 | r.bit()
+	}
+	return 1<<uint(zeros) - 1 + v
+}
+
+// se reads a signed Exp-Golomb code.
+func (r *bitReader) se() int32 {
+	v := r.ue()
+	if v%2 == 1 {
+		return int32(v/2 + 1)
+	}
+	return -int32(v / 2)
+}
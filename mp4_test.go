@@ -0,0 +1,268 @@
+package gadb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// bitWriter encodes H.264 syntax elements.
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) bits(v uint32, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte(v>>uint(i)&1) << (7 - uint(w.n%8))
+		w.n++
+	}
+}
+
+func (w *bitWriter) ue(v uint32) {
+	v++
+	length := 0
+	for x := v; x > 1; x >>= 1 {
+		length++
+	}
+	w.bits(0, length)
+	w.bits(v, length+1)
+}
+
+// testSPS encodes an SPS for a 4:2:0 progressive picture of the given
+// size, cropping it from whole macroblocks.
+func testSPS(profile byte, width, height int) []byte {
+	var w bitWriter
+	w.ue(0) // seq_parameter_set_id
+	if profile == 100 {
+		w.ue(1)      // chroma_format_idc
+		w.ue(0)      // bit_depth_luma_minus8
+		w.ue(0)      // bit_depth_chroma_minus8
+		w.bits(0, 1) // qpprime_y_zero_transform_bypass_flag
+		w.bits(1, 1) // seq_scaling_matrix_present_flag
+		w.bits(1, 1) // first list present, all deltas 0 but the last
+		for i := 0; i < 15; i++ {
+			w.ue(0)
+		}
+		w.ue(16) // delta -8: next scale 0 ends the list
+		w.bits(0, 7)
+	}
+	w.ue(0) // log2_max_frame_num_minus4
+	w.ue(0) // pic_order_cnt_type
+	w.ue(0) // log2_max_pic_order_cnt_lsb_minus4
+	w.ue(1) // max_num_ref_frames
+	w.bits(0, 1)
+	mbsW, mbsH := (width+15)/16, (height+15)/16
+	w.ue(uint32(mbsW - 1))
+	w.ue(uint32(mbsH - 1))
+	w.bits(1, 1) // frame_mbs_only_flag
+	w.bits(1, 1) // direct_8x8_inference_flag
+	if mbsW*16 != width || mbsH*16 != height {
+		w.bits(1, 1)
+		w.ue(0)
+		w.ue(uint32(mbsW*16-width) / 2)
+		w.ue(0)
+		w.ue(uint32(mbsH*16-height) / 2)
+	} else {
+		w.bits(0, 1)
+	}
+	w.bits(0, 1) // vui_parameters_present_flag
+	w.bits(1, 1) // rbsp_stop_one_bit
+	return append([]byte{0x67, profile, 0, 31}, w.data...)
+}
+
+func Test_parseSPS(t *testing.T) {
+	tests := []struct {
+		profile       byte
+		width, height int
+	}{
+		{66, 1280, 720},
+		{77, 1080, 2340},
+		{100, 1920, 1080},
+		{100, 720, 1600},
+	}
+	for _, tt := range tests {
+		sps, err := parseSPS(testSPS(tt.profile, tt.width, tt.height))
+		if err != nil {
+			t.Errorf("parseSPS(%d %dx%d): %v", tt.profile, tt.width, tt.height, err)
+			continue
+		}
+		if sps.width != tt.width || sps.height != tt.height || sps.highProfile != (tt.profile == 100) {
+			t.Errorf("parseSPS(%d %dx%d) = %+v", tt.profile, tt.width, tt.height, sps)
+		}
+	}
+	if _, err := parseSPS([]byte{0x67, 66, 0, 31}); err == nil {
+		t.Error("parseSPS accepted a truncated SPS")
+	}
+}
+
+func Test_unescapeRBSP(t *testing.T) {
+	got := unescapeRBSP([]byte{1, 0, 0, 3, 1, 0, 0, 3, 0, 3})
+	want := []byte{1, 0, 0, 1, 0, 0, 0, 3}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unescapeRBSP() = %v, want %v", got, want)
+	}
+}
+
+type mp4Box struct {
+	typ  string
+	data []byte
+}
+
+// readBoxes splits b into boxes, handling 64-bit sizes.
+func readBoxes(t *testing.T, b []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(b) > 0 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		header := uint64(8)
+		if size == 1 {
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			t.Fatalf("bad box size %d with %d bytes left", size, len(b))
+		}
+		boxes = append(boxes, mp4Box{typ: string(b[4:8]), data: b[header:size]})
+		b = b[size:]
+	}
+	return boxes
+}
+
+// findBox returns the box at path, descending through container boxes.
+func findBox(t *testing.T, b []byte, path ...string) []byte {
+	for _, typ := range path {
+		found := false
+		for _, box := range readBoxes(t, b) {
+			if box.typ == typ {
+				b, found = box.data, true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("no %s box in %v", typ, path)
+		}
+		if typ == "stsd" {
+			b = b[8:] // version, flags and entry count
+		}
+		if typ == "avc1" {
+			b = b[78:] // visual sample entry fields
+		}
+	}
+	return b
+}
+
+func u32s(b []byte) []uint32 {
+	var v []uint32
+	for ; len(b) >= 4; b = b[4:] {
+		v = append(v, binary.BigEndian.Uint32(b))
+	}
+	return v
+}
+
+func TestMP4Writer(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	m, err := NewMP4Writer(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(100, 0)
+	m.now = func() time.Time { return clock }
+
+	sps, pps := testSPS(66, 1280, 720), []byte{0x68, 0xce, 0x38, 0x80}
+	idr := []byte{0x65, 0x88, 0x84, 0x00, 0x33}
+	slice := func(first bool, payload byte) []byte {
+		if first {
+			return []byte{0x41, 0x9a, payload}
+		}
+		return []byte{0x41, 0x1a, payload}
+	}
+	start := []byte{0, 0, 0, 1}
+	var stream [][]byte
+	frame := func(nals ...[]byte) {
+		var b []byte
+		for _, nal := range nals {
+			b = append(append(b, start...), nal...)
+		}
+		stream = append(stream, b)
+	}
+	frame([]byte{0x09, 0xf0}, sps, pps, idr)        // 0ms
+	frame(slice(true, 1), slice(false, 2))          // 40ms, two slices
+	frame(slice(true, 3))                           // 80ms
+	frame(sps, pps, idr)                            // 200ms, repeated parameter sets
+	frame(slice(true, 4))                           // 240ms
+	offsets := []time.Duration{0, 40, 80, 200, 240} // milliseconds
+
+	for i, b := range stream {
+		clock = time.Unix(100, 0).Add(offsets[i] * time.Millisecond)
+		// split writes mid-NAL to exercise reassembly
+		half := len(b) / 2
+		if _, err = m.Write(b[:half]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = m.Write(b[half:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, box := range readBoxes(t, data) {
+		types = append(types, box.typ)
+	}
+	if !reflect.DeepEqual(types, []string{"ftyp", "mdat", "moov"}) {
+		t.Fatalf("top-level boxes = %v", types)
+	}
+
+	stbl := []string{"moov", "trak", "mdia", "minf", "stbl"}
+	avcC := findBox(t, data, append(stbl, "stsd", "avc1", "avcC")...)
+	if !bytes.Contains(avcC, sps) || !bytes.Contains(avcC, pps) {
+		t.Errorf("avcC = %x lacks the parameter sets", avcC)
+	}
+	tkhd := findBox(t, data, "moov", "trak", "tkhd")
+	if w, h := binary.BigEndian.Uint32(tkhd[76:])>>16, binary.BigEndian.Uint32(tkhd[80:])>>16; w != 1280 || h != 720 {
+		t.Errorf("track size = %dx%d", w, h)
+	}
+
+	stsz := u32s(findBox(t, data, append(stbl, "stsz")...))
+	wantSizes := []uint32{4 + uint32(len(idr)), 2 * (4 + 3), 4 + 3, 4 + uint32(len(idr)), 4 + 3}
+	if !reflect.DeepEqual(stsz[3:], wantSizes) {
+		t.Errorf("sample sizes = %v, want %v", stsz[3:], wantSizes)
+	}
+	stts := u32s(findBox(t, data, append(stbl, "stts")...))
+	wantStts := []uint32{0, 3, 2, 3600, 1, 10800, 2, 3600}
+	if !reflect.DeepEqual(stts, wantStts) {
+		t.Errorf("stts = %v, want %v", stts, wantStts)
+	}
+	stss := u32s(findBox(t, data, append(stbl, "stss")...))
+	if !reflect.DeepEqual(stss, []uint32{0, 2, 1, 4}) {
+		t.Errorf("stss = %v", stss)
+	}
+
+	// sample data is length-prefixed NAL units at the chunk offsets
+	stco := u32s(findBox(t, data, append(stbl, "stco")...))
+	if got := data[stco[2] : stco[2]+stsz[3]]; !bytes.Equal(got, append([]byte{0, 0, 0, byte(len(idr))}, idr...)) {
+		t.Errorf("first sample = %x", got)
+	}
+}
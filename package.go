@@ -0,0 +1,173 @@
+package gadb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Uninstall removes pkg from the device. With keepData, the app's data and
+// cache directories are kept (-k).
+func (d Device) Uninstall(pkg string, keepData bool) error {
+	features, err := d.featureSet()
+	if err != nil {
+		return err
+	}
+	args := []string{"uninstall"}
+	if keepData {
+		args = append(args, "-k")
+	}
+	out, err := d.pm(features, append(args, pkg)...)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "Success") {
+		return fmt.Errorf("pm uninstall %s: %s", pkg, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// ClearData deletes all data associated with pkg.
+func (d Device) ClearData(pkg string) error {
+	features, err := d.featureSet()
+	if err != nil {
+		return err
+	}
+	out, err := d.pm(features, "clear", pkg)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "Success") {
+		return fmt.Errorf("pm clear %s: %s", pkg, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// PackageFilter narrows the packages returned by ListPackages.
+type PackageFilter struct {
+	// System and ThirdParty keep only system (-s) or only third-party (-3) packages.
+	System     bool
+	ThirdParty bool
+	// Enabled and Disabled keep only enabled (-e) or only disabled (-d) packages.
+	Enabled  bool
+	Disabled bool
+	// User lists the packages of the given user id (--user).
+	User string
+	// Contains keeps only packages whose name contains the given text.
+	Contains string
+}
+
+// args returns the state selection flags; User and Contains are added by
+// ListPackages to each of its queries.
+func (f PackageFilter) args() []string {
+	var args []string
+	if f.System {
+		args = append(args, "-s")
+	}
+	if f.ThirdParty {
+		args = append(args, "-3")
+	}
+	if f.Enabled {
+		args = append(args, "-e")
+	}
+	if f.Disabled {
+		args = append(args, "-d")
+	}
+	return args
+}
+
+// Package describes an installed package as listed by `pm list packages`.
+type Package struct {
+	Name    string
+	APKPath string
+	UID     int
+	// Installer is the package that installed this one, or empty if unknown.
+	Installer   string
+	VersionCode int64
+	System      bool
+	Enabled     bool
+}
+
+// ListPackages lists the installed packages matching filter.
+func (d Device) ListPackages(filter PackageFilter) ([]Package, error) {
+	features, err := d.featureSet()
+	if err != nil {
+		return nil, err
+	}
+	list := func(args ...string) (string, error) {
+		args = append([]string{"list", "packages"}, args...)
+		if filter.User != "" {
+			args = append(args, "--user", filter.User)
+		}
+		if filter.Contains != "" {
+			args = append(args, filter.Contains)
+		}
+		return d.pm(features, args...)
+	}
+
+	out, err := list(append([]string{"-f", "-U", "-i", "--show-versioncode"}, filter.args()...)...)
+	if err != nil {
+		return nil, err
+	}
+	packages := parsePackageList(out)
+
+	// the detailed listing carries no state, so collect it separately
+	systemOut, err := list("-s")
+	if err != nil {
+		return nil, err
+	}
+	disabledOut, err := list("-d")
+	if err != nil {
+		return nil, err
+	}
+	system, disabled := packageNameSet(systemOut), packageNameSet(disabledOut)
+	for i := range packages {
+		packages[i].System = system[packages[i].Name]
+		packages[i].Enabled = !disabled[packages[i].Name]
+	}
+	return packages, nil
+}
+
+// parsePackageList parses `pm list packages -f -U -i --show-versioncode`
+// output, whose lines look like
+//
+//	package:/data/app/~~x==/com.example-y==/base.apk=com.example versionCode:42 installer=com.android.vending uid:10123
+func parsePackageList(output string) []Package {
+	var packages []Package
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(strings.TrimSpace(line))
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "package:") {
+			continue
+		}
+		pkg := Package{Name: strings.TrimPrefix(fields[0], "package:")}
+		// APK paths may themselves contain '=', package names never do
+		if i := strings.LastIndexByte(pkg.Name, '='); i >= 0 {
+			pkg.APKPath, pkg.Name = pkg.Name[:i], pkg.Name[i+1:]
+		}
+		for _, field := range fields[1:] {
+			switch {
+			case strings.HasPrefix(field, "versionCode:"):
+				pkg.VersionCode, _ = strconv.ParseInt(strings.TrimPrefix(field, "versionCode:"), 10, 64)
+			case strings.HasPrefix(field, "uid:"):
+				uid := strings.SplitN(strings.TrimPrefix(field, "uid:"), ",", 2)[0]
+				pkg.UID, _ = strconv.Atoi(uid)
+			case strings.HasPrefix(field, "installer="):
+				if installer := strings.TrimPrefix(field, "installer="); installer != "null" {
+					pkg.Installer = installer
+				}
+			}
+		}
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+func packageNameSet(output string) map[string]bool {
+	set := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if name := strings.TrimPrefix(strings.TrimSpace(line), "package:"); name != "" {
+			set[name] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,215 @@
+package gadb
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PackageInfo describes an installed package as reported by `dumpsys package`.
+type PackageInfo struct {
+	Name        string
+	VersionName string
+	VersionCode int64
+	// MinSDK is 0 on releases that do not report it.
+	MinSDK    int
+	TargetSDK int
+
+	FirstInstallTime time.Time
+	LastUpdateTime   time.Time
+
+	CodePath string
+	DataDir  string
+
+	RequestedPermissions []string
+	// GrantedPermissions lists the granted install-time permissions and the
+	// runtime permissions granted to the first user, sorted by name.
+	GrantedPermissions []string
+	// RuntimePermissions maps each runtime permission of the first user
+	// listed (normally user 0) to whether it is granted.
+	RuntimePermissions map[string]bool
+
+	// Activities lists the fully qualified class names of the activities
+	// that declare intent filters. dumpsys does not list the others.
+	Activities []string
+}
+
+// ErrPackageNotFound is returned by PackageInfo for unknown packages.
+var ErrPackageNotFound = errors.New("package not found")
+
+// PackageInfo returns the details of the installed package pkg.
+func (d Device) PackageInfo(pkg string) (PackageInfo, error) {
+	out, err := d.RunShellCommand("dumpsys", "package", ShellQuote(pkg))
+	if err != nil {
+		return PackageInfo{}, err
+	}
+	loc, err := d.timeZone()
+	if err != nil {
+		return PackageInfo{}, err
+	}
+	return parseDumpsysPackage(pkg, out, loc)
+}
+
+// timeZone returns the device's current UTC offset as a fixed zone, which
+// is what dumpsys timestamps are printed in.
+func (d Device) timeZone() (*time.Location, error) {
+	out, err := d.RunShellCommand("date", "+%z")
+	if err != nil {
+		return nil, err
+	}
+	t, err := time.Parse("-0700", strings.TrimSpace(out))
+	if err != nil {
+		return nil, fmt.Errorf("parse device time zone %q: %w", out, err)
+	}
+	_, offset := t.Zone()
+	return time.FixedZone(strings.TrimSpace(out), offset), nil
+}
+
+var (
+	resolverActivity = regexp.MustCompile(`^\s+[0-9a-f]+ ([\w.$]+)/([\w.$]+)(?: filter|$)`)
+	permissionState  = regexp.MustCompile(`^([\w.]+): granted=(true|false)`)
+)
+
+// parseDumpsysPackage parses the output of `dumpsys package <pkg>`.
+func parseDumpsysPackage(pkg, output string, loc *time.Location) (PackageInfo, error) {
+	info := PackageInfo{Name: pkg, RuntimePermissions: map[string]bool{}}
+	activities := map[string]bool{}
+	granted := map[string]bool{}
+
+	const (
+		none = iota
+		activityTable
+		packageBlock
+	)
+	section := none
+	inPackage, found := false, false
+	var permList string // which permission list the current lines belong to
+	userBlocks := 0
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			switch line {
+			case "Activity Resolver Table:":
+				section = activityTable
+			case "Packages:":
+				section = packageBlock
+			default:
+				section = none
+			}
+			continue
+		}
+
+		switch section {
+		case activityTable:
+			if m := resolverActivity.FindStringSubmatch(line); m != nil && m[1] == pkg {
+				class := m[2]
+				if strings.HasPrefix(class, ".") {
+					class = pkg + class
+				}
+				activities[class] = true
+			}
+
+		case packageBlock:
+			trimmed := strings.TrimSpace(line)
+			indent := len(line) - len(strings.TrimLeft(line, " "))
+			if indent == 2 && strings.HasPrefix(trimmed, "Package [") {
+				// only the first block: later ones are hidden system packages
+				inPackage = !found && strings.HasPrefix(trimmed, "Package ["+pkg+"]")
+				found = found || inPackage
+				continue
+			}
+			if !inPackage {
+				continue
+			}
+
+			switch {
+			case strings.HasSuffix(trimmed, "requested permissions:"):
+				permList = "requested"
+				continue
+			case strings.HasSuffix(trimmed, "install permissions:"):
+				permList = "install"
+				continue
+			case trimmed == "runtime permissions:":
+				userBlocks++
+				permList = "runtime"
+				continue
+			case strings.HasPrefix(trimmed, "User ") && indent == 4:
+				permList = ""
+				continue
+			}
+
+			if permList != "" && indent > 4 {
+				name := strings.SplitN(trimmed, ":", 2)[0]
+				switch permList {
+				case "requested":
+					info.RequestedPermissions = append(info.RequestedPermissions, name)
+				case "install", "runtime":
+					m := permissionState.FindStringSubmatch(trimmed)
+					if m == nil {
+						break
+					}
+					if permList == "runtime" {
+						if userBlocks > 1 {
+							break
+						}
+						info.RuntimePermissions[m[1]] = m[2] == "true"
+					}
+					if m[2] == "true" {
+						granted[m[1]] = true
+					}
+				}
+				continue
+			}
+			permList = ""
+
+			for _, kv := range strings.Fields(trimmed) {
+				parts := strings.SplitN(kv, "=", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				key, value := parts[0], parts[1]
+				switch key {
+				case "versionCode":
+					info.VersionCode, _ = strconv.ParseInt(value, 10, 64)
+				case "minSdk":
+					info.MinSDK, _ = strconv.Atoi(value)
+				case "targetSdk":
+					info.TargetSDK, _ = strconv.Atoi(value)
+				case "codePath":
+					info.CodePath = value
+				case "dataDir":
+					info.DataDir = value
+				}
+			}
+			switch {
+			case strings.HasPrefix(trimmed, "versionName="):
+				info.VersionName = strings.TrimPrefix(trimmed, "versionName=")
+			case strings.HasPrefix(trimmed, "firstInstallTime="):
+				info.FirstInstallTime, _ = time.ParseInLocation("2006-01-02 15:04:05", strings.TrimPrefix(trimmed, "firstInstallTime="), loc)
+			case strings.HasPrefix(trimmed, "lastUpdateTime="):
+				info.LastUpdateTime, _ = time.ParseInLocation("2006-01-02 15:04:05", strings.TrimPrefix(trimmed, "lastUpdateTime="), loc)
+			}
+		}
+	}
+
+	if !found {
+		return PackageInfo{}, fmt.Errorf("%w: %s", ErrPackageNotFound, pkg)
+	}
+	for name := range granted {
+		info.GrantedPermissions = append(info.GrantedPermissions, name)
+	}
+	sort.Strings(info.GrantedPermissions)
+	for class := range activities {
+		info.Activities = append(info.Activities, class)
+	}
+	sort.Strings(info.Activities)
+	return info, nil
+}
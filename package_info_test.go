@@ -0,0 +1,92 @@
+package gadb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testDumpsysPackage = `Activity Resolver Table:
+  Non-Data Actions:
+      android.intent.action.MAIN:
+        5b3a7e1 com.example/.MainActivity filter 8c2d1b6
+          Action: "android.intent.action.MAIN"
+          Category: "android.intent.category.LAUNCHER"
+        77aa001 com.other/.Main filter 1
+      com.example.OPEN:
+        4c4c4c4 com.example/com.example.share.ShareActivity
+
+Key Set Manager:
+  [com.example]
+      Signing KeySets: 51
+
+Packages:
+  Package [com.example] (4e8c2b9):
+    userId=10123
+    pkg=Package{a1b2c3 com.example}
+    codePath=/data/app/~~abc==/com.example-xyz==
+    primaryCpuAbi=arm64-v8a
+    versionCode=42 minSdk=24 targetSdk=34
+    versionName=1.2.3 (beta)
+    dataDir=/data/user/0/com.example
+    timeStamp=2024-03-14 08:15:30
+    firstInstallTime=2024-03-14 08:15:31
+    lastUpdateTime=2024-03-15 09:00:00
+    installerPackageName=com.android.vending
+    requested permissions:
+      android.permission.INTERNET
+      android.permission.CAMERA
+      android.permission.ACCESS_FINE_LOCATION: restricted=true
+    install permissions:
+      android.permission.INTERNET: granted=true
+    User 0: ceDataInode=12345 installed=true hidden=false suspended=false
+      gids=[3003]
+      runtime permissions:
+        android.permission.CAMERA: granted=false, flags=[ USER_SENSITIVE_WHEN_GRANTED|USER_SENSITIVE_WHEN_DENIED]
+        android.permission.ACCESS_FINE_LOCATION: granted=true
+    User 10: ceDataInode=0 installed=true hidden=false suspended=false
+      runtime permissions:
+        android.permission.CAMERA: granted=true
+
+Hidden system packages:
+  Package [com.example] (1111111):
+    versionCode=1 minSdk=21 targetSdk=21
+`
+
+func Test_parseDumpsysPackage(t *testing.T) {
+	loc := time.FixedZone("+0800", 8*3600)
+	info, err := parseDumpsysPackage("com.example", testDumpsysPackage, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := PackageInfo{
+		Name:             "com.example",
+		VersionName:      "1.2.3 (beta)",
+		VersionCode:      42,
+		MinSDK:           24,
+		TargetSDK:        34,
+		FirstInstallTime: time.Date(2024, 3, 14, 8, 15, 31, 0, loc),
+		LastUpdateTime:   time.Date(2024, 3, 15, 9, 0, 0, 0, loc),
+		CodePath:         "/data/app/~~abc==/com.example-xyz==",
+		DataDir:          "/data/user/0/com.example",
+		RequestedPermissions: []string{
+			"android.permission.INTERNET", "android.permission.CAMERA", "android.permission.ACCESS_FINE_LOCATION",
+		},
+		GrantedPermissions: []string{
+			"android.permission.ACCESS_FINE_LOCATION", "android.permission.INTERNET",
+		},
+		RuntimePermissions: map[string]bool{
+			"android.permission.CAMERA":               false,
+			"android.permission.ACCESS_FINE_LOCATION": true,
+		},
+		Activities: []string{"com.example.MainActivity", "com.example.share.ShareActivity"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got  %+v\nwant %+v", info, want)
+	}
+
+	if _, err := parseDumpsysPackage("com.missing", "Unable to find package: com.missing\n", loc); err == nil {
+		t.Error("expected ErrPackageNotFound")
+	}
+}
@@ -0,0 +1,22 @@
+package gadb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parsePackageList(t *testing.T) {
+	output := "package:/data/app/~~Yg8p==/com.example-Ab3==/base.apk=com.example versionCode:42 installer=com.android.vending uid:10123\r\n" +
+		"package:/system/priv-app/Settings/Settings.apk=com.android.settings versionCode:34 installer=null uid:1000\r\n" +
+		"\r\n"
+
+	want := []Package{
+		{Name: "com.example", APKPath: "/data/app/~~Yg8p==/com.example-Ab3==/base.apk", UID: 10123,
+			Installer: "com.android.vending", VersionCode: 42},
+		{Name: "com.android.settings", APKPath: "/system/priv-app/Settings/Settings.apk", UID: 1000,
+			VersionCode: 34},
+	}
+	if got := parsePackageList(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package gadb
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Permission is the state of a permission requested by a package.
+type Permission struct {
+	Name string
+	// Runtime is set for runtime ("dangerous") permissions, which can be
+	// granted and revoked after install.
+	Runtime bool
+	Granted bool
+}
+
+// GrantPermission grants the runtime permission perm to pkg.
+func (d Device) GrantPermission(pkg, perm string) error {
+	return d.changePermission("grant", pkg, perm)
+}
+
+// RevokePermission revokes the runtime permission perm from pkg.
+func (d Device) RevokePermission(pkg, perm string) error {
+	return d.changePermission("revoke", pkg, perm)
+}
+
+func (d Device) changePermission(verb, pkg, perm string) error {
+	features, err := d.featureSet()
+	if err != nil {
+		return err
+	}
+	out, err := d.pm(features, verb, pkg, perm)
+	if err != nil {
+		return err
+	}
+	// pm prints nothing on success and an exception otherwise
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("pm %s %s %s: %s", verb, pkg, perm, out)
+	}
+	return nil
+}
+
+// ListPermissions returns the permissions requested by pkg, in manifest
+// order, with their state for the first user.
+func (d Device) ListPermissions(pkg string) ([]Permission, error) {
+	info, err := d.PackageInfo(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return requestedPermissions(info), nil
+}
+
+func requestedPermissions(info PackageInfo) []Permission {
+	granted := map[string]bool{}
+	for _, name := range info.GrantedPermissions {
+		granted[name] = true
+	}
+	perms := make([]Permission, 0, len(info.RequestedPermissions))
+	for _, name := range info.RequestedPermissions {
+		_, runtime := info.RuntimePermissions[name]
+		perms = append(perms, Permission{Name: name, Runtime: runtime, Granted: granted[name]})
+	}
+	return perms
+}
+
+// GrantAllPermissions grants pkg every runtime permission its manifest
+// requests that is not granted yet, and returns the ones it granted.
+// It keeps going past permissions that cannot be granted, such as those
+// restricted to system apps, and reports them together in the error.
+func (d Device) GrantAllPermissions(pkg string) (granted []string, err error) {
+	perms, err := d.ListPermissions(pkg)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, perm := range perms {
+		if !perm.Runtime || perm.Granted {
+			continue
+		}
+		if grantErr := d.GrantPermission(pkg, perm.Name); grantErr != nil {
+			errs = append(errs, grantErr)
+			continue
+		}
+		granted = append(granted, perm.Name)
+	}
+	return granted, errors.Join(errs...)
+}
+
+// AppOpMode is the mode of an app op.
+type AppOpMode string
+
+const (
+	AppOpAllow   AppOpMode = "allow"
+	AppOpIgnore  AppOpMode = "ignore"
+	AppOpDeny    AppOpMode = "deny"
+	AppOpDefault AppOpMode = "default"
+	// AppOpForeground allows the op only while the app is in the foreground.
+	AppOpForeground AppOpMode = "foreground"
+)
+
+// AppOp is the mode of one app op of a package.
+type AppOp struct {
+	// Name is the op name as printed by appops, e.g. "CAMERA" or
+	// "RUN_IN_BACKGROUND".
+	Name string
+	Mode AppOpMode
+	// UIDMode is set when the mode applies to the package's whole uid
+	// rather than to the package alone.
+	UIDMode bool
+}
+
+// AppOps returns the app ops of pkg whose mode has been set or used.
+func (d Device) AppOps(pkg string) ([]AppOp, error) {
+	features, err := d.featureSet()
+	if err != nil {
+		return nil, err
+	}
+	out, err := d.serviceCommand(features, "appops", "appops", "get", pkg)
+	if err != nil {
+		return nil, err
+	}
+	return parseAppOps(out)
+}
+
+// GetAppOp returns the mode of the app op op for pkg. A uid mode, where
+// set, takes precedence over the package mode.
+func (d Device) GetAppOp(pkg, op string) (AppOpMode, error) {
+	features, err := d.featureSet()
+	if err != nil {
+		return "", err
+	}
+	out, err := d.serviceCommand(features, "appops", "appops", "get", pkg, op)
+	if err != nil {
+		return "", err
+	}
+	ops, err := parseAppOps(out)
+	if err != nil {
+		return "", err
+	}
+	mode := AppOpDefault
+	for _, o := range ops {
+		if o.Name != op {
+			continue
+		}
+		if o.UIDMode {
+			return o.Mode, nil
+		}
+		mode = o.Mode
+	}
+	return mode, nil
+}
+
+// SetAppOp sets the mode of the app op op for pkg.
+func (d Device) SetAppOp(pkg, op string, mode AppOpMode) error {
+	features, err := d.featureSet()
+	if err != nil {
+		return err
+	}
+	out, err := d.serviceCommand(features, "appops", "appops", "set", pkg, op, string(mode))
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("appops set %s %s %s: %s", pkg, op, mode, out)
+	}
+	return nil
+}
+
+var appOpLine = regexp.MustCompile(`^(Uid mode: )?([A-Z0-9_]+): ([a-z]+)\b`)
+
+// parseAppOps parses the output of `appops get`, whose op lines look like
+//
+//	Uid mode: COARSE_LOCATION: foreground
+//	CAMERA: allow; time=+1m2s ago
+//
+// Newer releases follow each op with indented access details.
+func parseAppOps(output string) ([]AppOp, error) {
+	var ops []AppOp
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || line == "No operations." || strings.HasPrefix(line, " ") {
+			continue
+		}
+		if m := appOpLine.FindStringSubmatch(line); m != nil {
+			ops = append(ops, AppOp{Name: m[2], Mode: AppOpMode(m[3]), UIDMode: m[1] != ""})
+			continue
+		}
+		if strings.HasPrefix(line, "Error") || strings.Contains(line, "Exception") {
+			return nil, fmt.Errorf("appops get: %s", strings.TrimSpace(output))
+		}
+	}
+	return ops, nil
+}
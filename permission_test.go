@@ -0,0 +1,53 @@
+package gadb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_requestedPermissions(t *testing.T) {
+	info := PackageInfo{
+		RequestedPermissions: []string{"android.permission.INTERNET", "android.permission.CAMERA", "android.permission.READ_CONTACTS"},
+		GrantedPermissions:   []string{"android.permission.CAMERA", "android.permission.INTERNET"},
+		RuntimePermissions:   map[string]bool{"android.permission.CAMERA": true, "android.permission.READ_CONTACTS": false},
+	}
+	want := []Permission{
+		{Name: "android.permission.INTERNET", Granted: true},
+		{Name: "android.permission.CAMERA", Runtime: true, Granted: true},
+		{Name: "android.permission.READ_CONTACTS", Runtime: true},
+	}
+	if got := requestedPermissions(info); !reflect.DeepEqual(got, want) {
+		t.Errorf("requestedPermissions() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_parseAppOps(t *testing.T) {
+	output := `Uid mode: COARSE_LOCATION: foreground
+CAMERA: allow; time=+1m2s ago
+RUN_IN_BACKGROUND: ignore
+COARSE_LOCATION: allow
+  null=[
+    Access: [top-s] 2024-01-02 03:04:05.678 (-3m4s)
+  ]
+`
+	want := []AppOp{
+		{Name: "COARSE_LOCATION", Mode: AppOpForeground, UIDMode: true},
+		{Name: "CAMERA", Mode: AppOpAllow},
+		{Name: "RUN_IN_BACKGROUND", Mode: AppOpIgnore},
+		{Name: "COARSE_LOCATION", Mode: AppOpAllow},
+	}
+	got, err := parseAppOps(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAppOps() = %+v, want %+v", got, want)
+	}
+
+	if got, err = parseAppOps("No operations.\n"); err != nil || len(got) != 0 {
+		t.Errorf("parseAppOps(no operations) = %v, %v", got, err)
+	}
+	if _, err = parseAppOps("Error: Unknown operation string: FOO\n"); err == nil {
+		t.Error("parseAppOps accepted an error")
+	}
+}
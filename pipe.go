@@ -0,0 +1,102 @@
+package gadb
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// pipeBufferSize bounds the data a pipe holds for its reader.
+const pipeBufferSize = 1 << 20
+
+// pipe is an in-memory pipe buffering up to pipeBufferSize bytes; writes
+// block only once that much is waiting to be read. The generous buffer
+// keeps a slow consumer of one stream (say stderr) from stalling delivery
+// of another (stdout) without tying up file descriptors, while bounding
+// the memory held for a reader that falls behind.
+type pipe struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     bytes.Buffer
+	wClosed bool
+	rClosed bool
+}
+
+type pipeReader struct{ p *pipe }
+
+type pipeWriter struct{ p *pipe }
+
+func newPipe() (*pipeReader, *pipeWriter) {
+	p := &pipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return &pipeReader{p}, &pipeWriter{p}
+}
+
+// Read reads buffered data, blocking until some is available. It returns
+// io.EOF once the writer has been closed and the buffer drained.
+func (r *pipeReader) Read(b []byte) (int, error) {
+	p := r.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.buf.Len() == 0 && !p.wClosed && !p.rClosed {
+		p.cond.Wait()
+	}
+	if p.rClosed {
+		return 0, io.ErrClosedPipe
+	}
+	if p.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	n, err := p.buf.Read(b)
+	p.cond.Broadcast()
+	return n, err
+}
+
+// Close discards any buffered data; subsequent writes fail with io.ErrClosedPipe.
+func (r *pipeReader) Close() error {
+	p := r.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rClosed = true
+	p.buf.Reset()
+	p.cond.Broadcast()
+	return nil
+}
+
+// Write blocks while the buffer is full. It fails with io.ErrClosedPipe
+// once either end is closed, having written part of b if space freed up
+// in the meantime.
+func (w *pipeWriter) Write(b []byte) (int, error) {
+	p := w.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	written := 0
+	for len(b) > 0 {
+		for p.buf.Len() >= pipeBufferSize && !p.rClosed && !p.wClosed {
+			p.cond.Wait()
+		}
+		if p.rClosed || p.wClosed {
+			return written, io.ErrClosedPipe
+		}
+		chunk := b
+		if space := pipeBufferSize - p.buf.Len(); len(chunk) > space {
+			chunk = chunk[:space]
+		}
+		n, _ := p.buf.Write(chunk)
+		written += n
+		b = b[n:]
+		p.cond.Broadcast()
+	}
+	return written, nil
+}
+
+// Close makes the reader return io.EOF after draining the buffered data,
+// and fails a Write blocked on a full buffer.
+func (w *pipeWriter) Close() error {
+	p := w.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wClosed = true
+	p.cond.Broadcast()
+	return nil
+}
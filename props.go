@@ -0,0 +1,227 @@
+package gadb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// propCache holds a device's read-only (ro.*) properties, which can only
+// change across a reboot. It is cleared when Logcat or WaitForProp wait for
+// the device to come back online. It is shared by the copies of a Device.
+type propCache struct {
+	mu sync.Mutex
+	ro map[string]string
+}
+
+func (c *propCache) get(name string) (string, bool) {
+	if c == nil || !strings.HasPrefix(name, "ro.") {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.ro[name]
+	return value, ok
+}
+
+// put caches the ro.* properties among props. Unset ones are left out as
+// they may still be set once.
+func (c *propCache) put(props map[string]string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, value := range props {
+		if strings.HasPrefix(name, "ro.") && value != "" {
+			if c.ro == nil {
+				c.ro = map[string]string{}
+			}
+			c.ro[name] = value
+		}
+	}
+}
+
+// clear forgets the cached properties.
+func (c *propCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ro = nil
+}
+
+// GetProp returns the value of a system property, or "" if it is unset.
+// Read-only properties are cached for devices obtained from DeviceList.
+func (d Device) GetProp(name string) (string, error) {
+	if value, ok := d.props.get(name); ok {
+		return value, nil
+	}
+	out, err := d.RunShellCommand("getprop", ShellQuote(name))
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimRight(out, "\r\n")
+	d.props.put(map[string]string{name: value})
+	return value, nil
+}
+
+// Props returns all system properties.
+func (d Device) Props() (map[string]string, error) {
+	out, err := d.RunShellCommand("getprop")
+	if err != nil {
+		return nil, err
+	}
+	props := parseProps(out)
+	d.props.put(props)
+	return props, nil
+}
+
+var propLine = regexp.MustCompile(`^\[([^\]]*)\]: \[(.*)$`)
+
+// parseProps parses the listing printed by getprop, whose lines look like
+//
+//	[ro.build.version.sdk]: [34]
+//
+// A value containing newlines continues on the following lines until one
+// ending with "]".
+func parseProps(output string) map[string]string {
+	props := map[string]string{}
+	var name string
+	var value []string
+	for _, line := range strings.Split(strings.Replace(output, "\r\n", "\n", -1), "\n") {
+		if value == nil {
+			m := propLine.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			name, value = m[1], []string{m[2]}
+		} else {
+			value = append(value, line)
+		}
+		if last := value[len(value)-1]; strings.HasSuffix(last, "]") {
+			value[len(value)-1] = strings.TrimSuffix(last, "]")
+			props[name] = strings.Join(value, "\n")
+			value = nil
+		}
+	}
+	return props
+}
+
+// SetProp sets a system property. Setting most properties requires root,
+// and read-only ones can only be set once.
+func (d Device) SetProp(name, value string) error {
+	out, err := d.RunShellCommand("setprop", ShellQuote(name), ShellQuote(value))
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("setprop %s: %s", name, out)
+	}
+	return nil
+}
+
+// propWaitInterval is how long WaitForProp waits before checking again
+// after losing the device.
+const propWaitInterval = time.Second
+
+// propWaitDone is printed by the polling loop of WaitForProp when the
+// property has the expected value.
+const propWaitDone = "gadb-prop-ready"
+
+// WaitForProp waits until the property name has the given value, e.g.
+// WaitForProp(ctx, "sys.boot_completed", "1"). The property is polled
+// on the device, so only a single command runs while waiting. If the
+// connection drops, such as while the device reboots, it waits for the
+// device to come back online and resumes.
+func (d Device) WaitForProp(ctx context.Context, name, value string) error {
+	if got, ok := d.props.get(name); ok && got == value {
+		return nil
+	}
+	// toybox sleep takes fractions of a second, older toolbox ones do not
+	cmd := fmt.Sprintf(`while [ "$(getprop %s)" != %s ]; do sleep 0.1 2>/dev/null || sleep 1; done; echo %s`,
+		ShellQuote(name), ShellQuote(value), propWaitDone)
+	for {
+		out, err := d.execOutput(ctx, cmd)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err == nil && bytes.Contains(out, []byte(propWaitDone)) {
+			return nil
+		}
+		debugLog(fmt.Sprintf("wait for %s: %v %s", name, err, bytes.TrimSpace(out)))
+		if err = d.waitForOnline(ctx, propWaitInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// BuildInfo describes the device's hardware and Android build.
+type BuildInfo struct {
+	// SDK is the API level, e.g. 34.
+	SDK int
+	// Release is the user-visible Android version, e.g. "14".
+	Release string
+	// ABIs lists the supported ABIs, most preferred first.
+	ABIs         []string
+	Manufacturer string
+	Brand        string
+	Model        string
+	Fingerprint  string
+}
+
+// BuildInfo reads the device's build properties. They are read-only, so
+// repeated calls are served from the cache.
+func (d Device) BuildInfo() (BuildInfo, error) {
+	props, err := d.roProps("ro.build.version.sdk", "ro.build.version.release",
+		"ro.product.cpu.abilist", "ro.product.cpu.abi", "ro.product.manufacturer", "ro.product.brand",
+		"ro.product.model", "ro.build.fingerprint")
+	if err != nil {
+		return BuildInfo{}, err
+	}
+	info := BuildInfo{
+		Release:      props["ro.build.version.release"],
+		ABIs:         splitABIs(props["ro.product.cpu.abilist"]),
+		Manufacturer: props["ro.product.manufacturer"],
+		Brand:        props["ro.product.brand"],
+		Model:        props["ro.product.model"],
+		Fingerprint:  props["ro.build.fingerprint"],
+	}
+	if len(info.ABIs) == 0 {
+		// releases before Lollipop only report the primary ABI
+		info.ABIs = splitABIs(props["ro.product.cpu.abi"])
+	}
+	info.SDK, _ = strconv.Atoi(props["ro.build.version.sdk"])
+	return info, nil
+}
+
+// roProps returns read-only properties, listing all properties once when
+// any of names is not cached yet. Unset names are not cached, so devices
+// leaving some of them out are listed on every call.
+func (d Device) roProps(names ...string) (map[string]string, error) {
+	props := make(map[string]string, len(names))
+	for _, name := range names {
+		value, ok := d.props.get(name)
+		if !ok {
+			return d.Props()
+		}
+		props[name] = value
+	}
+	return props, nil
+}
+
+func splitABIs(list string) []string {
+	var abis []string
+	for _, abi := range strings.Split(list, ",") {
+		if abi = strings.TrimSpace(abi); abi != "" {
+			abis = append(abis, abi)
+		}
+	}
+	return abis
+}
@@ -0,0 +1,109 @@
+package gadb
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseProps(t *testing.T) {
+	output := "[dalvik.vm.heapsize]: [512m]\r\n" +
+		"[persist.sys.motd]: [line one\r\nline [two]]\r\n" +
+		"[ro.build.version.sdk]: [34]\r\n" +
+		"[sys.boot_completed]: []\r\n"
+	want := map[string]string{
+		"dalvik.vm.heapsize":   "512m",
+		"persist.sys.motd":     "line one\nline [two]",
+		"ro.build.version.sdk": "34",
+		"sys.boot_completed":   "",
+	}
+	if got := parseProps(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseProps() = %q, want %q", got, want)
+	}
+}
+
+func TestDevice_BuildInfo_cached(t *testing.T) {
+	d := Device{props: &propCache{}}
+	d.props.put(map[string]string{
+		"ro.build.version.sdk":     "34",
+		"ro.build.version.release": "14",
+		"ro.product.cpu.abilist":   "arm64-v8a,armeabi-v7a,armeabi",
+		"ro.product.cpu.abi":       "arm64-v8a",
+		"ro.product.manufacturer":  "Google",
+		"ro.product.brand":         "google",
+		"ro.product.model":         "Pixel 8",
+		"ro.build.fingerprint":     "google/shiba/shiba:14/AP1A/1:user/release-keys",
+		"sys.boot_completed":       "1",
+	})
+	if _, ok := d.props.get("sys.boot_completed"); ok {
+		t.Error("cached a mutable property")
+	}
+
+	// served from the cache, so no adb server is needed
+	info, err := d.BuildInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BuildInfo{
+		SDK:          34,
+		Release:      "14",
+		ABIs:         []string{"arm64-v8a", "armeabi-v7a", "armeabi"},
+		Manufacturer: "Google",
+		Brand:        "google",
+		Model:        "Pixel 8",
+		Fingerprint:  "google/shiba/shiba:14/AP1A/1:user/release-keys",
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("BuildInfo() = %+v, want %+v", info, want)
+	}
+	if v, err := d.GetProp("ro.product.model"); err != nil || v != "Pixel 8" {
+		t.Errorf("GetProp() = %q, %v", v, err)
+	}
+}
+
+func TestDevice_BuildInfo_unsetProps(t *testing.T) {
+	// an old device without ro.product.cpu.abilist, whose ro.product.brand
+	// is only set by the time of the second listing
+	listings := []string{
+		"[ro.build.version.sdk]: [19]\r\n[ro.product.cpu.abi]: [armeabi-v7a]\r\n",
+		"[ro.build.version.sdk]: [19]\r\n[ro.product.cpu.abi]: [armeabi-v7a]\r\n[ro.product.brand]: [acme]\r\n",
+	}
+	f := &fakeADB{}
+	f.handle = func(service string, conn net.Conn) {
+		if n := len(f.calls()); service == "shell:getprop" && n <= len(listings) {
+			_, _ = io.WriteString(conn, listings[n-1])
+		}
+	}
+	d := newFakeADB(t, f)
+
+	for _, brand := range []string{"", "acme"} {
+		info, err := d.BuildInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.SDK != 19 || !reflect.DeepEqual(info.ABIs, []string{"armeabi-v7a"}) || info.Brand != brand {
+			t.Errorf("BuildInfo() = %+v, want brand %q", info, brand)
+		}
+	}
+	if v, err := d.GetProp("ro.build.version.sdk"); err != nil || v != "19" {
+		t.Errorf("GetProp() = %q, %v", v, err)
+	}
+	if calls := f.calls(); !reflect.DeepEqual(calls, []string{"shell:getprop", "shell:getprop"}) {
+		t.Errorf("calls = %q, want a listing per BuildInfo", calls)
+	}
+}
+
+func TestDevice_waitForOnline_clearsProps(t *testing.T) {
+	d := newFakeADB(t, &fakeADB{})
+	d.props.put(map[string]string{"ro.build.fingerprint": "old/build"})
+	if err := d.waitForOnline(context.Background(), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// the device may have rebooted into another build
+	if v, ok := d.props.get("ro.build.fingerprint"); ok {
+		t.Errorf("cached %q across a reconnection", v)
+	}
+}
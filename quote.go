@@ -0,0 +1,76 @@
+package gadb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ShellQuote returns arg quoted so that the device shell (mksh, or toybox sh
+// on newer builds) passes it to the command as a single literal word.
+// Arguments made only of characters that have no meaning to the shell are
+// returned unchanged.
+func ShellQuote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if isShellSafe(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// ShellJoin quotes each of args with ShellQuote and joins them with spaces.
+func ShellJoin(args ...string) string {
+	quoted := make([]string, len(args))
+	for i := range args {
+		quoted[i] = ShellQuote(args[i])
+	}
+	return strings.Join(quoted, " ")
+}
+
+func isShellSafe(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("@%+:,./-_", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// shellEnvPrefix renders env ("KEY=value" pairs) as variable assignments
+// that can be prepended to a command line.
+func shellEnvPrefix(env []string) (string, error) {
+	var sb strings.Builder
+	for _, kv := range env {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return "", fmt.Errorf("invalid environment variable %q: missing '='", kv)
+		}
+		if !isShellName(kv[:i]) {
+			return "", fmt.Errorf("invalid environment variable name %q", kv[:i])
+		}
+		sb.WriteString(kv[:i])
+		sb.WriteByte('=')
+		sb.WriteString(ShellQuote(kv[i+1:]))
+		sb.WriteByte(' ')
+	}
+	return sb.String(), nil
+}
+
+func isShellName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
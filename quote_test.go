@@ -0,0 +1,88 @@
+package gadb
+
+import (
+	"os/exec"
+	"testing"
+)
+
+var trickyArgs = []string{
+	"",
+	"plain",
+	"/sdcard/My Photos/IMG 001.jpg",
+	"it's",
+	`"double"`,
+	"$HOME",
+	"`id`",
+	"$(reboot)",
+	"a; rm -rf /",
+	"a && b || c",
+	"x|y",
+	"*.apk",
+	"~user",
+	"#comment",
+	"{a,b}",
+	"back\\slash",
+	"new\nline",
+	"tab\there",
+	"'''",
+	"KEY=value",
+	"--es=日本語",
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"", "''"},
+		{"ls", "ls"},
+		{"/data/local/tmp/a.apk", "/data/local/tmp/a.apk"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+		{"a;b", "'a;b'"},
+		{"K=V", "'K=V'"},
+	}
+	for _, tt := range tests {
+		if got := ShellQuote(tt.arg); got != tt.want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestShellQuote_roundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	for _, arg := range trickyArgs {
+		out, err := exec.Command(sh, "-c", "printf %s "+ShellQuote(arg)).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", arg, err)
+		}
+		if string(out) != arg {
+			t.Errorf("round trip of %q produced %q", arg, out)
+		}
+	}
+}
+
+func TestCmd_String(t *testing.T) {
+	cmd := Device{}.Command("ls", "-l", "/sdcard/My Files")
+	cmd.Dir = "/data/local/tmp"
+	cmd.Env = []string{"LANG=C", "GREETING=hello world"}
+
+	want := `cd /data/local/tmp && LANG=C GREETING='hello world' ls -l '/sdcard/My Files'`
+	if got := cmd.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestCmd_StartInvalidEnv(t *testing.T) {
+	for _, env := range []string{"NOEQUALS", "1ABC=x", "A-B=x", "=x", "A;reboot=x"} {
+		cmd := Device{}.Command("true")
+		cmd.Env = []string{env}
+		if err := cmd.Start(); err == nil {
+			t.Errorf("Start with Env %q: expected error", env)
+		}
+	}
+}
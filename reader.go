@@ -3,6 +3,8 @@ package gadb
 import (
 	"context"
 	"io"
+	"sync"
+	"time"
 )
 
 type readerCtx struct {
@@ -14,10 +16,55 @@ func (r *readerCtx) Read(p []byte) (n int, err error) {
 	if err := r.ctx.Err(); err != nil {
 		return 0, err
 	}
-	return r.r.Read(p)
+	if conn, ok := r.r.(interface{ SetReadDeadline(time.Time) error }); ok {
+		// expire the deadline on cancellation to interrupt a blocked Read
+		stop := context.AfterFunc(r.ctx, func() { _ = conn.SetReadDeadline(time.Unix(1, 0)) })
+		defer stop()
+	}
+	n, err = r.r.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		err = r.ctx.Err()
+	}
+	return
 }
 
-// NewReader gets a context-aware io.Reader.
+// NewReader gets a context-aware io.Reader. Cancelling ctx fails the next
+// Read; if r has a SetReadDeadline method, as a net.Conn does, a Read that
+// is already blocked is interrupted too.
 func NewReader(ctx context.Context, r io.Reader) io.Reader {
 	return &readerCtx{ctx: ctx, r: r}
 }
+
+// ctxReadCloser closes the underlying reader when ctx is done, which unblocks
+// any Read in progress.
+type ctxReadCloser struct {
+	ctx  context.Context
+	rc   io.ReadCloser
+	stop chan struct{}
+	once sync.Once
+}
+
+func newCtxReadCloser(ctx context.Context, rc io.ReadCloser) *ctxReadCloser {
+	r := &ctxReadCloser{ctx: ctx, rc: rc, stop: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = rc.Close()
+		case <-r.stop:
+		}
+	}()
+	return r
+}
+
+func (r *ctxReadCloser) Read(p []byte) (n int, err error) {
+	n, err = r.rc.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		err = r.ctx.Err()
+	}
+	return
+}
+
+func (r *ctxReadCloser) Close() error {
+	r.once.Do(func() { close(r.stop) })
+	return r.rc.Close()
+}
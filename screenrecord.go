@@ -0,0 +1,138 @@
+package gadb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// screenrecordMaxSegment is the longest recording screenrecord makes in
+// one run.
+const screenrecordMaxSegment = 180 * time.Second
+
+// ScreenRecordOptions configures ScreenRecord.
+type ScreenRecordOptions struct {
+	// Width and Height set the video size (--size), by default the
+	// display's native resolution.
+	Width, Height int
+	// BitRate is the video bit rate in bits per second (--bit-rate).
+	BitRate int
+	// TimeLimit ends the recording after the given duration, which may
+	// exceed the 3 minutes screenrecord allows per run. Zero records until
+	// ctx is cancelled.
+	TimeLimit time.Duration
+	// DisplayID records the given physical display (--display-id), as
+	// listed by Displays, instead of the default one.
+	DisplayID uint64
+	// Rotate rotates the output by 90 degrees (--rotate).
+	Rotate bool
+}
+
+func (opts ScreenRecordOptions) command(limit time.Duration) string {
+	args := []string{"screenrecord", "--output-format=h264"}
+	if opts.Width > 0 && opts.Height > 0 {
+		args = append(args, "--size", fmt.Sprintf("%dx%d", opts.Width, opts.Height))
+	}
+	if opts.BitRate > 0 {
+		args = append(args, "--bit-rate", strconv.Itoa(opts.BitRate))
+	}
+	if limit > 0 {
+		// screenrecord takes whole seconds; round up so no footage is lost
+		args = append(args, "--time-limit", strconv.Itoa(int((limit+time.Second-1)/time.Second)))
+	}
+	if opts.DisplayID != 0 {
+		args = append(args, "--display-id", strconv.FormatUint(opts.DisplayID, 10))
+	}
+	if opts.Rotate {
+		args = append(args, "--rotate")
+	}
+	return ShellJoin(append(args, "-")...)
+}
+
+// ScreenRecord records the screen as a raw H.264 elementary stream (Annex
+// B) written to w, straight from `screenrecord` without going through
+// device storage. It records until ctx is cancelled or opts.TimeLimit
+// elapses, restarting screenrecord whenever a run hits its 3 minute limit;
+// each restart begins a new coded video sequence in the same stream.
+// Cancelling ctx is the normal way to stop and returns ctx.Err().
+//
+// To get an MP4 file instead, pass an *MP4Writer and Close it afterwards.
+func (d Device) ScreenRecord(ctx context.Context, w io.Writer, opts ScreenRecordOptions) error {
+	var deadline time.Time
+	if opts.TimeLimit > 0 {
+		deadline = time.Now().Add(opts.TimeLimit)
+	}
+	for {
+		limit := screenrecordMaxSegment
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining < time.Second {
+				return nil
+			}
+			if remaining < limit {
+				limit = remaining
+			}
+		}
+
+		seg := &h264SegmentWriter{w: w}
+		err := d.copyService(ctx, "exec:"+opts.command(limit), seg)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return err
+		}
+		if err = seg.err(); err != nil {
+			return err
+		}
+	}
+}
+
+// h264SegmentWriter forwards the output of one screenrecord run, checking
+// that it starts as an H.264 stream. Anything else is an error message,
+// which is held back from the destination.
+type h264SegmentWriter struct {
+	w    io.Writer
+	head []byte
+	// valid is set once the output is known to be a video stream
+	valid bool
+}
+
+// h264SegmentMaxMessage bounds the error text kept from a failed run.
+const h264SegmentMaxMessage = 4096
+
+func (s *h264SegmentWriter) Write(p []byte) (int, error) {
+	if s.valid {
+		return s.w.Write(p)
+	}
+	if len(s.head) < h264SegmentMaxMessage {
+		s.head = append(s.head, p...)
+	}
+	if len(s.head) < 4 {
+		return len(p), nil
+	}
+	if !bytes.HasPrefix(s.head, []byte{0, 0, 0, 1}) && !bytes.HasPrefix(s.head, []byte{0, 0, 1}) {
+		return len(p), nil
+	}
+	s.valid = true
+	if _, err := s.w.Write(s.head); err != nil {
+		return 0, err
+	}
+	s.head = nil
+	return len(p), nil
+}
+
+func (s *h264SegmentWriter) err() error {
+	if s.valid {
+		return nil
+	}
+	if msg := strings.TrimSpace(string(s.head)); msg != "" {
+		return fmt.Errorf("screenrecord: %s", msg)
+	}
+	return errors.New("screenrecord: no output")
+}
@@ -0,0 +1,36 @@
+package gadb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestScreenRecordOptions_command(t *testing.T) {
+	opts := ScreenRecordOptions{Width: 720, Height: 1280, BitRate: 4000000, DisplayID: 4619827259835644672, Rotate: true}
+	want := "screenrecord '--output-format=h264' --size 720x1280 --bit-rate 4000000 --time-limit 10 " +
+		"--display-id 4619827259835644672 --rotate -"
+	if got := opts.command(9500 * time.Millisecond); got != want {
+		t.Errorf("command = %s\nwant      %s", got, want)
+	}
+}
+
+func Test_h264SegmentWriter(t *testing.T) {
+	var out bytes.Buffer
+	seg := &h264SegmentWriter{w: &out}
+	for _, p := range [][]byte{{0, 0}, {0, 1, 0x67}, {0x42}} {
+		if _, err := seg.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := seg.err(); err != nil || !bytes.Equal(out.Bytes(), []byte{0, 0, 0, 1, 0x67, 0x42}) {
+		t.Errorf("output = %x, err = %v", out.Bytes(), err)
+	}
+
+	out.Reset()
+	seg = &h264SegmentWriter{w: &out}
+	_, _ = seg.Write([]byte("ERROR: unable to create encoder input surface\n"))
+	if err := seg.err(); err == nil || out.Len() != 0 {
+		t.Errorf("output = %q, err = %v", out.Bytes(), err)
+	}
+}
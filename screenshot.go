@@ -0,0 +1,246 @@
+package gadb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Screenshot captures the default display. It reads the raw framebuffer
+// through the framebuffer: service, falling back to decoding the PNG
+// written by `screencap -p` on devices where that fails.
+// Colors are returned as stored, without converting wide color gamuts
+// such as Display P3 to sRGB.
+func (d Device) Screenshot(ctx context.Context) (image.Image, error) {
+	img, err := d.framebuffer(ctx)
+	if err == nil {
+		return img, nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	debugLog("framebuffer: " + err.Error() + ", falling back to screencap")
+	return d.screencap(ctx, "screencap -p")
+}
+
+// ScreenshotDisplay captures the display with the given physical display
+// ID, as listed by Displays, using `screencap -d`.
+func (d Device) ScreenshotDisplay(ctx context.Context, displayID uint64) (image.Image, error) {
+	return d.screencap(ctx, "screencap -p -d "+strconv.FormatUint(displayID, 10))
+}
+
+// Display describes a physical display.
+type Display struct {
+	ID uint64
+	// Name is the display name reported by SurfaceFlinger, if any.
+	Name string
+}
+
+var displayLine = regexp.MustCompile(`^Display (\d+)(?:.*displayName="([^"]*)")?`)
+
+// Displays lists the physical displays of the device, the default one
+// first.
+func (d Device) Displays() ([]Display, error) {
+	out, err := d.RunShellCommand("dumpsys", "SurfaceFlinger", "--display-id")
+	if err != nil {
+		return nil, err
+	}
+	return parseDisplays(out), nil
+}
+
+// parseDisplays parses `dumpsys SurfaceFlinger --display-id`, whose lines
+// look like
+//
+//	Display 4619827259835644672 (HWC display 0): port=0 pnpId=GGL displayName="EMU_display_0"
+func parseDisplays(output string) []Display {
+	var displays []Display
+	for _, line := range strings.Split(output, "\n") {
+		m := displayLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		id, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		displays = append(displays, Display{ID: id, Name: m[2]})
+	}
+	return displays
+}
+
+func (d Device) screencap(ctx context.Context, cmd string) (image.Image, error) {
+	r, err := d.ExecOutReader(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("\x89PNG")) {
+		return nil, fmt.Errorf("%s: %s", cmd, strings.TrimSpace(string(data)))
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: decode: %w", cmd, err)
+	}
+	return img, nil
+}
+
+func (d Device) framebuffer(ctx context.Context) (image.Image, error) {
+	tp, err := d.openService("framebuffer:")
+	if err != nil {
+		return nil, err
+	}
+	r := newCtxReadCloser(ctx, tp.sock)
+	defer func() { _ = r.Close() }()
+	return readFramebuffer(bufio.NewReader(r))
+}
+
+// framebufferHeader is the header sent by the framebuffer: service.
+type framebufferHeader struct {
+	Version uint32
+	BPP     uint32
+	// ColorSpace is 0 (unknown), 1 (sRGB) or 2 (Display P3), from version 2.
+	ColorSpace uint32
+	Size       uint32
+	Width      uint32
+	Height     uint32
+	// channel bit offsets and lengths, in the order sent
+	RedOffset, RedLength     uint32
+	BlueOffset, BlueLength   uint32
+	GreenOffset, GreenLength uint32
+	AlphaOffset, AlphaLength uint32
+}
+
+// framebufferLegacyVersion is the first word of the original header, which
+// only held the bits per pixel of its RGB565 format.
+const framebufferLegacyVersion = 16
+
+// framebufferSizeSlack is how much padding beyond the pixels a framebuffer
+// may declare.
+const framebufferSizeSlack = 4096
+
+// framebufferMaxSize bounds the decoded image of a framebuffer, at 4 bytes
+// per pixel, well above that of an 8K display.
+const framebufferMaxSize = 256 << 20
+
+// readFramebuffer decodes the output of the framebuffer: service.
+func readFramebuffer(r io.Reader) (image.Image, error) {
+	var h framebufferHeader
+	if err := binary.Read(r, binary.LittleEndian, &h.Version); err != nil {
+		return nil, fmt.Errorf("read framebuffer header: %w", err)
+	}
+
+	var fields []*uint32
+	switch h.Version {
+	case framebufferLegacyVersion:
+		h.BPP = 16
+		h.RedOffset, h.RedLength = 11, 5
+		h.GreenOffset, h.GreenLength = 5, 6
+		h.BlueOffset, h.BlueLength = 0, 5
+		fields = []*uint32{&h.Size, &h.Width, &h.Height}
+	case 1, 2:
+		fields = []*uint32{&h.BPP}
+		if h.Version == 2 {
+			fields = append(fields, &h.ColorSpace)
+		}
+		fields = append(fields, &h.Size, &h.Width, &h.Height,
+			&h.RedOffset, &h.RedLength, &h.BlueOffset, &h.BlueLength,
+			&h.GreenOffset, &h.GreenLength, &h.AlphaOffset, &h.AlphaLength)
+	default:
+		return nil, fmt.Errorf("unsupported framebuffer version %d", h.Version)
+	}
+	for _, f := range fields {
+		if err := binary.Read(r, binary.LittleEndian, f); err != nil {
+			return nil, fmt.Errorf("read framebuffer header: %w", err)
+		}
+	}
+
+	if h.BPP == 0 || h.BPP%8 != 0 || h.BPP > 32 {
+		return nil, fmt.Errorf("unsupported framebuffer depth %d", h.BPP)
+	}
+	if h.Width == 0 || h.Height == 0 {
+		return nil, errors.New("framebuffer is empty")
+	}
+	// the header is untrusted, so bound the allocations by the image size
+	if uint64(h.Width)*uint64(h.Height)*4 > framebufferMaxSize {
+		return nil, fmt.Errorf("framebuffer %dx%d too large", h.Width, h.Height)
+	}
+	stride := int(h.Width) * int(h.BPP/8)
+	want := uint64(stride) * uint64(h.Height)
+	if uint64(h.Size) < want {
+		return nil, fmt.Errorf("framebuffer size %d too small for %dx%d at %d bpp", h.Size, h.Width, h.Height, h.BPP)
+	}
+	if uint64(h.Size) > want+framebufferSizeSlack {
+		return nil, fmt.Errorf("framebuffer size %d too large for %dx%d at %d bpp", h.Size, h.Width, h.Height, h.BPP)
+	}
+	data := make([]byte, h.Size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("read framebuffer: %w", err)
+	}
+	return h.decode(data, stride), nil
+}
+
+func (h framebufferHeader) decode(data []byte, stride int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, int(h.Width), int(h.Height)))
+
+	// RGBA_8888, the common case, is already laid out as NRGBA
+	if h.BPP == 32 && h.RedOffset == 0 && h.GreenOffset == 8 && h.BlueOffset == 16 &&
+		h.RedLength == 8 && h.GreenLength == 8 && h.BlueLength == 8 &&
+		(h.AlphaLength == 0 || h.AlphaOffset == 24 && h.AlphaLength == 8) {
+		for y := 0; y < int(h.Height); y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+stride]
+			copy(row, data[y*stride:])
+			if h.AlphaLength == 0 {
+				for x := 3; x < len(row); x += 4 {
+					row[x] = 0xff
+				}
+			}
+		}
+		return img
+	}
+
+	bytesPerPixel := int(h.BPP / 8)
+	for y := 0; y < int(h.Height); y++ {
+		for x := 0; x < int(h.Width); x++ {
+			var pixel uint32
+			for i, b := range data[y*stride+x*bytesPerPixel : y*stride+(x+1)*bytesPerPixel] {
+				pixel |= uint32(b) << (8 * uint(i))
+			}
+			c := color.NRGBA{
+				R: channel(pixel, h.RedOffset, h.RedLength),
+				G: channel(pixel, h.GreenOffset, h.GreenLength),
+				B: channel(pixel, h.BlueOffset, h.BlueLength),
+				A: 0xff,
+			}
+			if h.AlphaLength != 0 {
+				c.A = channel(pixel, h.AlphaOffset, h.AlphaLength)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// channel extracts a color channel and scales it to 8 bits.
+func channel(pixel, offset, length uint32) uint8 {
+	if length == 0 || length > 16 {
+		return 0
+	}
+	full := uint32(1)<<length - 1
+	v := pixel >> offset & full
+	return uint8((v*0xff + full/2) / full)
+}
@@ -0,0 +1,108 @@
+package gadb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func framebufferBytes(header []uint32, data []byte) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func Test_readFramebuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream []byte
+		want   []color.NRGBA
+	}{
+		{
+			name: "v1 RGBA_8888",
+			stream: framebufferBytes(
+				[]uint32{1, 32, 8, 2, 1, 0, 8, 16, 8, 8, 8, 24, 8},
+				[]byte{0x10, 0x20, 0x30, 0xff, 0x40, 0x50, 0x60, 0x80}),
+			want: []color.NRGBA{{0x10, 0x20, 0x30, 0xff}, {0x40, 0x50, 0x60, 0x80}},
+		},
+		{
+			name: "v2 RGBX_8888",
+			stream: framebufferBytes(
+				[]uint32{2, 32, 1, 8, 1, 2, 0, 8, 16, 8, 8, 8, 24, 0},
+				[]byte{0x10, 0x20, 0x30, 0x00, 0x40, 0x50, 0x60, 0x00}),
+			want: []color.NRGBA{{0x10, 0x20, 0x30, 0xff}, {0x40, 0x50, 0x60, 0xff}},
+		},
+		{
+			name: "v1 BGRA_8888",
+			stream: framebufferBytes(
+				[]uint32{1, 32, 4, 1, 1, 16, 8, 0, 8, 8, 8, 24, 8},
+				[]byte{0x30, 0x20, 0x10, 0xff}),
+			want: []color.NRGBA{{0x10, 0x20, 0x30, 0xff}},
+		},
+		{
+			name: "v2 RGB_565",
+			stream: framebufferBytes(
+				[]uint32{2, 16, 0, 4, 2, 1, 11, 5, 0, 5, 5, 6, 0, 0},
+				// pure red, pure blue
+				[]byte{0x00, 0xf8, 0x1f, 0x00}),
+			want: []color.NRGBA{{0xff, 0, 0, 0xff}, {0, 0, 0xff, 0xff}},
+		},
+		{
+			name:   "legacy RGB_565",
+			stream: framebufferBytes([]uint32{16, 2, 1, 1}, []byte{0xe0, 0x07}),
+			want:   []color.NRGBA{{0, 0xff, 0, 0xff}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := readFramebuffer(bytes.NewReader(tt.stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []color.NRGBA
+			b := img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					got = append(got, img.(*image.NRGBA).NRGBAAt(x, y))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pixels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readFramebuffer(bytes.NewReader(framebufferBytes([]uint32{1, 32, 100, 10, 10}, nil))); err == nil {
+		t.Error("accepted a truncated framebuffer")
+	}
+	// a corrupt header must not make readFramebuffer allocate gigabytes
+	for _, header := range [][]uint32{
+		{1, 32, 0xffffffff, 2, 1, 0, 8, 16, 8, 8, 8, 24, 8},
+		{1, 32, 0xfffffff0, 0x7fff, 0x7fff, 0, 8, 16, 8, 8, 8, 24, 8},
+		// small at 8 bpp, but not once decoded to NRGBA
+		{1, 8, 20000 * 10000, 20000, 10000, 0, 3, 3, 2, 6, 2, 0, 0},
+		{1, 32, 0, 0, 10, 0, 8, 16, 8, 8, 8, 24, 8},
+	} {
+		if _, err := readFramebuffer(bytes.NewReader(framebufferBytes(header, nil))); err == nil || strings.HasPrefix(err.Error(), "read framebuffer:") {
+			t.Errorf("framebuffer size %d for %dx%d: err = %v, want the header rejected", header[2], header[3], header[4], err)
+		}
+	}
+	if _, err := readFramebuffer(bytes.NewReader(framebufferBytes([]uint32{3}, nil))); err == nil {
+		t.Error("accepted an unknown version")
+	}
+}
+
+func Test_parseDisplays(t *testing.T) {
+	output := `Display 4619827259835644672 (HWC display 0): port=0 pnpId=GGL displayName="EMU_display_0"
+Display 4619827551948147201 (HWC display 1): port=1 pnpId=GGL displayName="EMU_display_1"
+`
+	want := []Display{{4619827259835644672, "EMU_display_0"}, {4619827551948147201, "EMU_display_1"}}
+	if got := parseDisplays(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDisplays() = %v, want %v", got, want)
+	}
+}
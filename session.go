@@ -9,7 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"sync"
 )
 
 // A Session represents a connection to a remote command or shell.
@@ -31,8 +31,10 @@ type Session struct {
 	Stderr io.Writer
 
 	transport      *transport
+	env            []string
 	errorChan      chan error
 	abort          bool
+	closeMu        sync.Mutex
 	handlesToClose []io.Closer
 }
 
@@ -87,6 +89,8 @@ func (s *Session) Close() error {
 }
 
 func (s *Session) closeFiles() error {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
 	var err error
 	for _, f := range s.handlesToClose {
 		fErr := f.Close()
@@ -137,13 +141,36 @@ func (s *Session) Run(cmd string) error {
 	return nil
 }
 
+// Setenv sets an environment variable that will be applied to any command
+// executed by Start or Run. The assignment is prepended to the command line.
+func (s *Session) Setenv(name, value string) error {
+	if s.errorChan != nil {
+		return errors.New("Setenv called after Start()")
+	}
+	if !isShellName(name) {
+		return fmt.Errorf("invalid environment variable name %q", name)
+	}
+	s.env = append(s.env, name+"="+value)
+	return nil
+}
+
 // Start runs cmd on the remote host.
 func (s *Session) Start(cmd string) error {
+	env, err := shellEnvPrefix(s.env)
+	if err != nil {
+		return err
+	}
+	return s.start(fmt.Sprintf("shell,v2,raw:%s%s", env, cmd))
+}
+
+// start opens service, which must speak the shell protocol, and wires up
+// the session's standard streams.
+func (s *Session) start(service string) error {
 	if s.errorChan != nil {
 		return errors.New("Start() already called")
 	}
 
-	if err := s.transport.Send(fmt.Sprintf("shell,v2,raw:%s", cmd)); err != nil {
+	if err := s.transport.Send(service); err != nil {
 		return fmt.Errorf("failed to send shell cmd: %w", err)
 	}
 	if err := s.transport.VerifyResponse(); err != nil {
@@ -154,7 +181,8 @@ func (s *Session) Start(cmd string) error {
 		return fmt.Errorf("failed to create shell transport: %w", err)
 	}
 
-	s.errorChan = make(chan error)
+	// buffered so that neither goroutine leaks if Wait is never called
+	s.errorChan = make(chan error, 2)
 	s.abort = false
 	// Copy stdin to remote command
 	if s.Stdin != nil {
@@ -162,6 +190,10 @@ func (s *Session) Start(cmd string) error {
 			buffer := make([]byte, 1024)
 			for !s.abort {
 				n, err := s.Stdin.Read(buffer)
+				if errors.Is(err, io.ErrClosedPipe) {
+					// StdinPipe closed on our side after the command exited
+					return
+				}
 				if err == io.EOF {
 					if err := shellTp.Send(shellCloseStdin, []byte{}); err != nil {
 						s.errorChan <- fmt.Errorf("failed to close stdin: %w", err)
@@ -180,6 +212,12 @@ func (s *Session) Start(cmd string) error {
 			return fmt.Errorf("failed to close stdin: %w", err)
 		}
 	}
+	// fail closes the pipes before reporting, so that readers see EOF
+	// rather than blocking until Wait.
+	fail := func(err error) {
+		_ = s.closeFiles()
+		s.errorChan <- err
+	}
 	go func() {
 		for !s.abort {
 			msgType, msg, err := shellTp.Read()
@@ -187,21 +225,21 @@ func (s *Session) Start(cmd string) error {
 				break
 			}
 			if err != nil {
-				s.errorChan <- fmt.Errorf("failed to read shell msg: %w", err)
+				fail(fmt.Errorf("failed to read shell msg: %w", err))
 				return
 			}
 			switch msgType {
 			case shellStdout: // stdout
 				if s.Stdout != nil {
 					if _, err := s.Stdout.Write(msg); err != nil {
-						s.errorChan <- fmt.Errorf("failed to write stdout: %w", err)
+						fail(fmt.Errorf("failed to write stdout: %w", err))
 						return
 					}
 				}
 			case shellStderr: // stderr
 				if s.Stderr != nil {
 					if _, err := s.Stderr.Write(msg); err != nil {
-						s.errorChan <- fmt.Errorf("failed to write stderr: %w", err)
+						fail(fmt.Errorf("failed to write stderr: %w", err))
 						return
 					}
 				}
@@ -220,16 +258,20 @@ func (s *Session) Start(cmd string) error {
 				}
 				return
 			default:
-				s.errorChan <- fmt.Errorf("unexpected shell message %d: %w", msgType, err)
+				fail(fmt.Errorf("unexpected shell message %d: %w", msgType, err))
 				return
 			}
 		}
-		s.errorChan <- &ExitMissingError{}
+		fail(&ExitMissingError{})
 	}()
 	return nil
 }
 
 // StderrPipe returns a pipe that will be connected to the remote command's standard error when the command starts.
+// The pipe is buffered in memory and reports EOF once the command's exit status has arrived.
+// It holds up to 1 MiB of unread data; beyond that the session stops reading the connection,
+// stalling the command's other streams too, until the pipe is read. Read it concurrently with
+// the other pipes, and before calling Wait.
 func (s *Session) StderrPipe() (io.Reader, error) {
 	if s.Stderr != nil {
 		return nil, errors.New("can't set Stderr and call StderrPipe()")
@@ -237,16 +279,15 @@ func (s *Session) StderrPipe() (io.Reader, error) {
 	if s.errorChan != nil {
 		return nil, errors.New("StderrPipe called after Start()")
 	}
-	pr, pw, err := os.Pipe()
-	if err != nil {
-		return nil, err
-	}
+	pr, pw := newPipe()
 	s.Stderr = pw
 	s.handlesToClose = append(s.handlesToClose, pw)
 	return pr, nil
 }
 
 // StdinPipe returns a pipe that will be connected to the remote command's standard input when the command starts.
+// Writes are buffered in memory, up to 1 MiB, and block once that much is waiting to be sent;
+// closing the pipe closes the command's standard input.
 func (s *Session) StdinPipe() (io.WriteCloser, error) {
 	if s.Stdin != nil {
 		return nil, errors.New("can't set Stdin and call StdinPipe()")
@@ -254,16 +295,17 @@ func (s *Session) StdinPipe() (io.WriteCloser, error) {
 	if s.errorChan != nil {
 		return nil, errors.New("StdinPipe called after Start()")
 	}
-	pr, pw, err := os.Pipe()
-	if err != nil {
-		return nil, err
-	}
+	pr, pw := newPipe()
 	s.Stdin = pr
 	s.handlesToClose = append(s.handlesToClose, pr)
 	return pw, nil
 }
 
 // StdoutPipe returns a pipe that will be connected to the remote command's standard output when the command starts.
+// The pipe is buffered in memory and reports EOF once the command's exit status has arrived.
+// It holds up to 1 MiB of unread data; beyond that the session stops reading the connection,
+// stalling the command's other streams too, until the pipe is read. Read it concurrently with
+// the other pipes, and before calling Wait.
 func (s *Session) StdoutPipe() (io.Reader, error) {
 	if s.Stdout != nil {
 		return nil, errors.New("can't set Stdout and call StdoutPipe()")
@@ -271,10 +313,7 @@ func (s *Session) StdoutPipe() (io.Reader, error) {
 	if s.errorChan != nil {
 		return nil, errors.New("StdoutPipe called after Start()")
 	}
-	pr, pw, err := os.Pipe()
-	if err != nil {
-		return nil, err
-	}
+	pr, pw := newPipe()
 	s.Stdout = pw
 	s.handlesToClose = append(s.handlesToClose, pw)
 	return pr, nil
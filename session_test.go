@@ -0,0 +1,130 @@
+package gadb
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	pr, pw := newPipe()
+	for i := 0; i < 1000; i++ {
+		if _, err := pw.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = pw.Close()
+	if _, err := pw.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("write after close: %v", err)
+	}
+
+	raw, err := ioutil.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 10000 {
+		t.Errorf("read %d bytes, want 10000", len(raw))
+	}
+
+	pr, pw = newPipe()
+	_ = pr.Close()
+	if _, err := pw.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("write after reader close: %v", err)
+	}
+}
+
+func TestPipe_bounded(t *testing.T) {
+	pr, pw := newPipe()
+	written := make(chan int, 1)
+	go func() {
+		n, _ := pw.Write(make([]byte, pipeBufferSize+10))
+		written <- n
+	}()
+
+	select {
+	case n := <-written:
+		t.Fatalf("write of %d bytes did not block on a full pipe", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n, err := io.ReadFull(pr, make([]byte, 100)); err != nil || n != 100 {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	select {
+	case n := <-written:
+		if n != pipeBufferSize+10 {
+			t.Errorf("wrote %d bytes, want %d", n, pipeBufferSize+10)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write still blocked after the reader drained the pipe")
+	}
+
+	// closing the writer fails a blocked write but keeps the buffered data
+	pr, pw = newPipe()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := pw.Write(make([]byte, pipeBufferSize+1))
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_ = pw.Close()
+	if err := <-errs; err != io.ErrClosedPipe {
+		t.Errorf("blocked write after close: %v", err)
+	}
+	if raw, _ := ioutil.ReadAll(pr); len(raw) != pipeBufferSize {
+		t.Errorf("read %d bytes after close, want %d", len(raw), pipeBufferSize)
+	}
+}
+
+func TestSession_pipes(t *testing.T) {
+	client, server := tcpPair(t)
+
+	service := make(chan string, 1)
+	go func() {
+		r := bufio.NewReader(server)
+		head := make([]byte, 4)
+		_, _ = io.ReadFull(r, head)
+		n, _ := strconv.ParseInt(string(head), 16, 32)
+		body := make([]byte, n)
+		_, _ = io.ReadFull(r, body)
+		service <- string(body)
+
+		_, _ = server.Write([]byte("OKAY"))
+		device := newShellTransport(server, 0)
+		// far more output than an OS pipe buffer holds, before any stderr
+		for i := 0; i < 2000; i++ {
+			_ = device.Send(shellStdout, []byte(strings.Repeat("o", 100)))
+		}
+		_ = device.Send(shellStderr, []byte("done\n"))
+		_ = device.Send(shellExit, []byte{0})
+	}()
+
+	s := &Session{transport: &transport{sock: client, readTimeout: time.Second}}
+	if err := s.Setenv("LANG", "C.UTF-8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Setenv("1BAD", "x"); err == nil {
+		t.Error("expected error for invalid variable name")
+	}
+	stdout, _ := s.StdoutPipe()
+	stderr, _ := s.StderrPipe()
+	if err := s.Start("echo hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-service, "shell,v2,raw:LANG=C.UTF-8 echo hi"; got != want {
+		t.Errorf("service %q, want %q", got, want)
+	}
+
+	// reading stderr first must not deadlock on the unread stdout
+	errOut, _ := ioutil.ReadAll(stderr)
+	out, _ := ioutil.ReadAll(stdout)
+	if string(errOut) != "done\n" || len(out) != 200000 {
+		t.Errorf("stderr %q, stdout %d bytes", errOut, len(out))
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
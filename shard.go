@@ -0,0 +1,350 @@
+package gadb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShardMode selects how RunSharded splits the tests between devices.
+type ShardMode int
+
+const (
+	// ShardStatic splits the tests into NumShards shards by index, using
+	// the runner's own sharding (numShards/shardIndex).
+	ShardStatic ShardMode = iota
+	// ShardByClass runs one test class at a time, handing the next class
+	// to whichever device becomes free first.
+	ShardByClass
+)
+
+// ShardOptions configures RunSharded.
+type ShardOptions struct {
+	// Runner is the instrumentation component, e.g.
+	// "com.example.test/androidx.test.runner.AndroidJUnitRunner".
+	Runner string
+	// AppAPKs and TestAPKs are the files of the app under test and of the
+	// test app, each a base APK optionally followed by its splits. They are
+	// installed on every device first; either may be left empty when the
+	// app is already installed.
+	AppAPKs  []string
+	TestAPKs []string
+	Install  InstallOptions
+
+	// Instrument holds the options common to every shard.
+	Instrument InstrumentOptions
+	Mode       ShardMode
+	// NumShards is the number of static shards, by default the number of
+	// devices.
+	NumShards int
+	// Classes lists the test classes to queue in ShardByClass mode. When
+	// empty they are discovered with a dry run (-e log true).
+	Classes []string
+
+	// Retries is how many times a failed test is run again, on another
+	// device when there is one.
+	Retries int
+	// OnEvent, if set, is called with every test event and the serial of
+	// the device reporting it, leaving out the dry run discovering Classes.
+	// It is called from several goroutines.
+	OnEvent func(serial string, ev TestEvent)
+}
+
+// RunSharded runs an instrumentation split across devices and merges the
+// results into one. A device that fails to install the APKs or drops its
+// connection is taken out of the pool, its unfinished work going to the
+// others. Failures on the device, such as crashes, are reported in the
+// result's RunError, prefixed by the device serial.
+func RunSharded(ctx context.Context, devices []Device, opts ShardOptions) (*InstrumentResult, error) {
+	start := time.Now()
+	devices, err := installShards(ctx, devices, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	bySerial := map[string]Device{}
+	serials := make([]string, len(devices))
+	for i, dev := range devices {
+		bySerial[dev.serial] = dev
+		serials[i] = dev.serial
+	}
+	runner := func(onEvent func(serial string, ev TestEvent)) shardRunFunc {
+		return func(ctx context.Context, serial string, iopts InstrumentOptions) (*InstrumentResult, error) {
+			stream, err := bySerial[serial].Instrument(ctx, opts.Runner, iopts)
+			if err != nil {
+				return nil, err
+			}
+			for ev := range stream.Events() {
+				if onEvent != nil {
+					onEvent(serial, ev)
+				}
+			}
+			if err = stream.Err(); err != nil {
+				return nil, err
+			}
+			return stream.Result(), nil
+		}
+	}
+	run := runner(opts.OnEvent)
+
+	var work []shardWork
+	switch opts.Mode {
+	case ShardStatic:
+		n := opts.NumShards
+		if n <= 0 {
+			n = len(devices)
+		}
+		for i := 0; i < n; i++ {
+			shard := opts.Instrument
+			shard.NumShards, shard.ShardIndex = n, i
+			work = append(work, shardWork{opts: shard, name: fmt.Sprintf("shard %d/%d", i, n)})
+		}
+	case ShardByClass:
+		classes := opts.Classes
+		if len(classes) == 0 {
+			// the dry run reports every test as passed, which is no event
+			// for OnEvent
+			if classes, err = discoverTestClasses(ctx, serials[0], opts.Instrument, runner(nil)); err != nil {
+				return nil, err
+			}
+		}
+		for _, class := range classes {
+			shard := opts.Instrument
+			shard.Classes = []string{class}
+			work = append(work, shardWork{opts: shard, name: class})
+		}
+	default:
+		return nil, fmt.Errorf("unknown shard mode %d", opts.Mode)
+	}
+
+	result, err := scheduleShards(ctx, serials, work, opts.Retries, run)
+	if result != nil {
+		result.Runner = opts.Runner
+		result.Duration = time.Since(start)
+	}
+	return result, err
+}
+
+// installShards installs the APKs on every device in parallel and returns
+// the devices that succeeded.
+func installShards(ctx context.Context, devices []Device, opts ShardOptions) ([]Device, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices to run on")
+	}
+	errs := make([]error, len(devices))
+	var wg sync.WaitGroup
+	for i, dev := range devices {
+		wg.Add(1)
+		go func(i int, dev Device) {
+			defer wg.Done()
+			for _, apks := range [][]string{opts.AppAPKs, opts.TestAPKs} {
+				if len(apks) == 0 {
+					continue
+				}
+				if err := dev.InstallAPKFiles(ctx, apks, opts.Install); err != nil {
+					errs[i] = fmt.Errorf("%s: install %s: %w", dev.serial, apks[0], err)
+					return
+				}
+			}
+		}(i, dev)
+	}
+	wg.Wait()
+
+	var ok []Device
+	for i, dev := range devices {
+		if errs[i] == nil {
+			ok = append(ok, dev)
+		} else {
+			debugLog(errs[i].Error())
+		}
+	}
+	if len(ok) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return ok, nil
+}
+
+// discoverTestClasses lists the test classes a run would execute, in order,
+// by running it with the runner's dry run option.
+func discoverTestClasses(ctx context.Context, serial string, opts InstrumentOptions, run shardRunFunc) ([]string, error) {
+	args := map[string]string{"log": "true"}
+	for k, v := range opts.Args {
+		args[k] = v
+	}
+	opts.Args = args
+	result, err := run(ctx, serial, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: list tests: %w", serial, err)
+	}
+	if result.RunError != "" {
+		return nil, fmt.Errorf("%s: list tests: %s", serial, result.RunError)
+	}
+	var classes []string
+	seen := map[string]bool{}
+	for _, t := range result.Tests {
+		if !seen[t.Test.Class] {
+			seen[t.Test.Class] = true
+			classes = append(classes, t.Test.Class)
+		}
+	}
+	return classes, nil
+}
+
+type shardRunFunc func(ctx context.Context, serial string, opts InstrumentOptions) (*InstrumentResult, error)
+
+// shardWork is one instrumentation run to schedule.
+type shardWork struct {
+	opts InstrumentOptions
+	// name identifies the work in run errors.
+	name string
+	// avoid is the device a retried test failed on, and attempt counts
+	// the retries.
+	avoid   string
+	attempt int
+}
+
+// shardQueue hands out work to the device workers.
+type shardQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	work []shardWork
+	// running counts the work items taken and not yet finished, which may
+	// still produce retries or be put back.
+	running int
+	workers int
+}
+
+// take returns the next work item serial may run, waiting while others are
+// still running. It returns false once all work is done or ctx is done.
+func (q *shardQueue) take(ctx context.Context, serial string) (shardWork, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ctx.Err() == nil {
+		for i, w := range q.work {
+			// a retry avoids its failing device unless none other is left
+			if w.avoid != serial || q.workers == 1 {
+				q.work = append(q.work[:i], q.work[i+1:]...)
+				q.running++
+				return w, true
+			}
+		}
+		if len(q.work) == 0 && q.running == 0 {
+			return shardWork{}, false
+		}
+		q.cond.Wait()
+	}
+	return shardWork{}, false
+}
+
+// finish marks a taken item as done, queueing any follow-up work.
+func (q *shardQueue) finish(more ...shardWork) {
+	q.mu.Lock()
+	q.work = append(q.work, more...)
+	q.running--
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// leave removes a worker from the pool.
+func (q *shardQueue) leave() {
+	q.mu.Lock()
+	q.workers--
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// scheduleShards runs work on the devices with the given serials, one item
+// per device at a time, and merges the results. Failed tests are rerun up
+// to retries times. A device whose run fails with an error leaves the
+// pool and its work item is put back for the others.
+func scheduleShards(ctx context.Context, serials []string, work []shardWork, retries int, run shardRunFunc) (*InstrumentResult, error) {
+	q := &shardQueue{work: work, workers: len(serials)}
+	q.cond = sync.NewCond(&q.mu)
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	})
+	defer stop()
+
+	var mu sync.Mutex
+	merged := &InstrumentResult{}
+	index := map[TestID]int{}
+	var runErrors []string
+	var deviceErrs []error
+
+	record := func(serial string, w shardWork, result *InstrumentResult) (retry []shardWork) {
+		mu.Lock()
+		defer mu.Unlock()
+		if result.RunError != "" {
+			runErrors = append(runErrors, fmt.Sprintf("%s: %s: %s", serial, w.name, result.RunError))
+		}
+		for _, t := range result.Tests {
+			t.Retries = w.attempt
+			if i, seen := index[t.Test]; seen {
+				merged.Tests[i] = t
+			} else {
+				index[t.Test] = len(merged.Tests)
+				merged.Tests = append(merged.Tests, t)
+			}
+			if t.Status == TestFailed && w.attempt < retries {
+				id := t.Test
+				rerun := w.opts
+				rerun.Classes, rerun.Packages, rerun.NumShards = []string{id.String()}, nil, 0
+				retry = append(retry, shardWork{
+					opts:    rerun,
+					name:    id.String(),
+					avoid:   serial,
+					attempt: w.attempt + 1,
+				})
+			}
+		}
+		return retry
+	}
+
+	var wg sync.WaitGroup
+	for _, serial := range serials {
+		wg.Add(1)
+		go func(serial string) {
+			defer wg.Done()
+			defer q.leave()
+			for {
+				w, ok := q.take(ctx, serial)
+				if !ok {
+					return
+				}
+				result, err := run(ctx, serial, w.opts)
+				if err != nil {
+					// the device is unusable: hand the work to the others
+					mu.Lock()
+					deviceErrs = append(deviceErrs, fmt.Errorf("%s: %s: %w", serial, w.name, err))
+					mu.Unlock()
+					q.finish(w)
+					return
+				}
+				q.finish(record(serial, w, result)...)
+			}
+		}(serial)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return merged, err
+	}
+	if len(q.work) != 0 {
+		var names []string
+		for _, w := range q.work {
+			names = append(names, w.name)
+		}
+		err := fmt.Errorf("no device left to run %s", strings.Join(names, ", "))
+		return merged, errors.Join(append(deviceErrs, err)...)
+	}
+	for _, err := range deviceErrs {
+		debugLog(err.Error())
+	}
+	merged.RunError = strings.Join(runErrors, "\n")
+	return merged, nil
+}
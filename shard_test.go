@@ -0,0 +1,147 @@
+package gadb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_scheduleShards(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	run := func(ctx context.Context, serial string, opts InstrumentOptions) (*InstrumentResult, error) {
+		target := strings.Join(opts.Classes, ",")
+		mu.Lock()
+		calls = append(calls, serial+" "+target)
+		mu.Unlock()
+		if serial == "broken" {
+			return nil, errors.New("device offline")
+		}
+
+		result := &InstrumentResult{}
+		add := func(class, method string, status TestStatus) {
+			result.Tests = append(result.Tests, TestResult{Test: TestID{class, method}, Status: status, Device: serial})
+		}
+		switch target {
+		case "com.example.A":
+			add("com.example.A", "one", TestPassed)
+		case "com.example.B":
+			// flaky on whichever device runs it first
+			add("com.example.B", "one", TestPassed)
+			add("com.example.B", "two", TestFailed)
+		case "com.example.B#two":
+			add("com.example.B", "two", TestPassed)
+		case "com.example.C":
+			add("com.example.C", "one", TestFailed)
+			result.RunError = "Process crashed."
+		case "com.example.C#one":
+			add("com.example.C", "one", TestFailed)
+		}
+		return result, nil
+	}
+
+	var work []shardWork
+	for _, class := range []string{"com.example.A", "com.example.B", "com.example.C"} {
+		work = append(work, shardWork{opts: InstrumentOptions{Classes: []string{class}}, name: class})
+	}
+	result, err := scheduleShards(context.Background(), []string{"broken", "dev1", "dev2"}, work, 1, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]TestResult{}
+	for _, r := range result.Tests {
+		got[r.Test.String()] = r
+	}
+	if len(result.Tests) != 4 {
+		t.Fatalf("tests = %+v", result.Tests)
+	}
+	if r := got["com.example.B#two"]; r.Status != TestPassed || r.Retries != 1 {
+		t.Errorf("B#two = %+v", r)
+	}
+	if r := got["com.example.C#one"]; r.Status != TestFailed || r.Retries != 1 {
+		t.Errorf("C#one = %+v", r)
+	}
+	if !strings.Contains(result.RunError, "com.example.C: Process crashed.") {
+		t.Errorf("RunError = %q", result.RunError)
+	}
+
+	// retries must run on the other healthy device
+	first := map[string]string{}
+	for _, call := range calls {
+		parts := strings.SplitN(call, " ", 2)
+		if parts[0] == "broken" {
+			continue
+		}
+		target := parts[1]
+		if i := strings.IndexByte(target, '#'); i >= 0 {
+			if first[target[:i]] == parts[0] {
+				t.Errorf("%s retried on the device it failed on", target)
+			}
+			continue
+		}
+		first[target] = parts[0]
+	}
+}
+
+func Test_scheduleShards_noDevices(t *testing.T) {
+	run := func(ctx context.Context, serial string, opts InstrumentOptions) (*InstrumentResult, error) {
+		return nil, errors.New("device offline")
+	}
+	work := []shardWork{{name: "shard 0/1"}}
+	if _, err := scheduleShards(context.Background(), []string{"a"}, work, 0, run); err == nil || !strings.Contains(err.Error(), "no device left") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestRunSharded_discoveryEvents(t *testing.T) {
+	// both the dry run and the real one report com.example.A#one as passed
+	const output = "INSTRUMENTATION_STATUS: class=com.example.A\n" +
+		"INSTRUMENTATION_STATUS: test=one\n" +
+		"INSTRUMENTATION_STATUS_CODE: 1\n" +
+		"INSTRUMENTATION_STATUS: class=com.example.A\n" +
+		"INSTRUMENTATION_STATUS: test=one\n" +
+		"INSTRUMENTATION_STATUS_CODE: 0\n" +
+		"INSTRUMENTATION_RESULT: stream=OK (1 test)\n" +
+		"INSTRUMENTATION_CODE: -1\n"
+	f := &fakeADB{handle: func(service string, conn net.Conn) {
+		_, _ = io.WriteString(conn, output)
+	}}
+	d := newFakeADB(t, f)
+
+	var mu sync.Mutex
+	var events []TestEvent
+	result, err := RunSharded(context.Background(), []Device{d}, ShardOptions{
+		Runner: "com.example.test/androidx.test.runner.AndroidJUnitRunner",
+		Mode:   ShardByClass,
+		OnEvent: func(serial string, ev TestEvent) {
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Tests) != 1 {
+		t.Errorf("result tests = %+v", result.Tests)
+	}
+
+	calls := f.calls()
+	if len(calls) != 2 || !strings.Contains(calls[0], "-e log true") || !strings.Contains(calls[1], "-e class com.example.A") {
+		t.Fatalf("calls = %q, want a dry run then com.example.A", calls)
+	}
+	var passed int
+	for _, ev := range events {
+		if ev.Status == TestPassed {
+			passed++
+		}
+	}
+	if passed != 1 {
+		t.Errorf("OnEvent saw %d passed tests, want only the real run's", passed)
+	}
+}
@@ -0,0 +1,222 @@
+package gadb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// streamState tracks the lifetime of a stream fed by a background goroutine.
+type streamState struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func newStreamState(ctx context.Context) *streamState {
+	ctx, cancel := context.WithCancel(ctx)
+	return &streamState{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// closeOnDone closes c once the stream is cancelled, unblocking any read
+// in progress on it.
+func (s *streamState) closeOnDone(c io.Closer) {
+	go func() {
+		select {
+		case <-s.ctx.Done():
+		case <-s.done:
+		}
+		_ = c.Close()
+	}()
+}
+
+func (s *streamState) finish(err error) {
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	s.err = err
+	s.cancel()
+	close(s.done)
+}
+
+// Err waits for the stream to end and returns the error that ended it,
+// or nil if the remote command completed successfully.
+func (s *streamState) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close stops the stream and waits for it to shut down.
+func (s *streamState) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// ShellLine is one line of output from a streamed shell command, without
+// its line terminator.
+type ShellLine struct {
+	Text string
+	// Stderr is set when the line was written to standard error. It is only
+	// ever set on devices supporting the shell_v2 feature.
+	Stderr bool
+}
+
+// ShellStream delivers the output of a long-running shell command line by
+// line. Lines are not buffered: if the consumer stops reading, the command
+// is eventually throttled by the connection's flow control.
+type ShellStream struct {
+	*streamState
+	lines chan ShellLine
+}
+
+// Lines returns the channel of output lines. It is closed when the command
+// exits or the stream is cancelled, after which Err reports why.
+func (s *ShellStream) Lines() <-chan ShellLine {
+	return s.lines
+}
+
+// StreamShell starts cmd through the device shell and streams its output.
+// Cancelling ctx, or calling Close, terminates the command.
+// A non-zero exit status is reported by Err as an *ExitError.
+func (d Device) StreamShell(ctx context.Context, cmd string) (*ShellStream, error) {
+	if strings.TrimSpace(cmd) == "" {
+		return nil, errors.New("adb shell: command cannot be empty")
+	}
+	shellV2, err := d.HasFeature("shell_v2")
+	if err != nil {
+		return nil, err
+	}
+
+	var tp transport
+	if shellV2 {
+		tp, err = d.openService("shell,v2,raw:" + cmd)
+	} else {
+		tp, err = d.openService("shell:" + cmd)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ShellStream{streamState: newStreamState(ctx), lines: make(chan ShellLine)}
+	s.closeOnDone(tp)
+	go func() {
+		defer close(s.lines)
+		if shellV2 {
+			s.finish(s.readShellProtocol(tp))
+		} else {
+			s.finish(s.readRaw(tp))
+		}
+	}()
+	return s, nil
+}
+
+func (s *ShellStream) emit(line ShellLine) bool {
+	select {
+	case s.lines <- line:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+func (s *ShellStream) readRaw(tp transport) error {
+	r := bufio.NewReader(tp.sock)
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" && !s.emit(ShellLine{Text: trimLineEnd(line)}) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *ShellStream) readShellProtocol(tp transport) error {
+	shellTp, err := tp.CreateShellTransport()
+	if err != nil {
+		return fmt.Errorf("failed to create shell transport: %w", err)
+	}
+	if err := shellTp.Send(shellCloseStdin, []byte{}); err != nil {
+		return fmt.Errorf("failed to close stdin: %w", err)
+	}
+
+	var stdout, stderr lineBuffer
+	for {
+		msgType, msg, err := shellTp.Read()
+		if err == io.EOF {
+			return &ExitMissingError{}
+		}
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case shellStdout:
+			if !stdout.write(msg, func(l string) bool { return s.emit(ShellLine{Text: l}) }) {
+				return nil
+			}
+		case shellStderr:
+			if !stderr.write(msg, func(l string) bool { return s.emit(ShellLine{Text: l, Stderr: true}) }) {
+				return nil
+			}
+		case shellExit:
+			if rest, ok := stdout.flush(); ok && !s.emit(ShellLine{Text: rest}) {
+				return nil
+			}
+			if rest, ok := stderr.flush(); ok && !s.emit(ShellLine{Text: rest, Stderr: true}) {
+				return nil
+			}
+			if exitCode := int(msg[0]); exitCode != 0 {
+				return &ExitError{Waitmsg: Waitmsg{exitStatus: exitCode}}
+			}
+			return nil
+		default:
+			return fmt.Errorf("unexpected shell message %d", msgType)
+		}
+	}
+}
+
+// lineBuffer reassembles lines split across shell protocol packets.
+type lineBuffer struct {
+	buf []byte
+}
+
+// write appends p and calls emit for each complete line, stopping early if
+// emit returns false.
+func (b *lineBuffer) write(p []byte, emit func(string) bool) bool {
+	b.buf = append(b.buf, p...)
+	for {
+		i := bytes.IndexByte(b.buf, '\n')
+		if i < 0 {
+			return true
+		}
+		line := trimLineEnd(string(b.buf[:i+1]))
+		b.buf = b.buf[i+1:]
+		if !emit(line) {
+			return false
+		}
+	}
+}
+
+// flush returns any trailing text not terminated by a newline.
+func (b *lineBuffer) flush() (string, bool) {
+	if len(b.buf) == 0 {
+		return "", false
+	}
+	rest := trimLineEnd(string(b.buf))
+	b.buf = nil
+	return rest, true
+}
+
+func trimLineEnd(line string) string {
+	return strings.TrimRight(line, "\r\n")
+}
@@ -0,0 +1,76 @@
+package gadb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestShellStream_shellProtocol(t *testing.T) {
+	client, server := tcpPair(t)
+
+	go func() {
+		device := newShellTransport(server, 0)
+		_ = device.Send(shellStdout, []byte("hel"))
+		_ = device.Send(shellStdout, []byte("lo\r\nwor"))
+		_ = device.Send(shellStderr, []byte("oops\n"))
+		_ = device.Send(shellStdout, []byte("ld"))
+		_ = device.Send(shellExit, []byte{3})
+	}()
+
+	s := &ShellStream{streamState: newStreamState(context.Background()), lines: make(chan ShellLine)}
+	go func() {
+		defer close(s.lines)
+		s.finish(s.readShellProtocol(transport{sock: client}))
+	}()
+
+	var got []ShellLine
+	for line := range s.Lines() {
+		got = append(got, line)
+	}
+	want := []ShellLine{
+		{Text: "hello"},
+		{Text: "oops", Stderr: true},
+		{Text: "world"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var exitErr *ExitError
+	if err := s.Err(); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+}
+
+func TestShellStream_cancel(t *testing.T) {
+	client, server := tcpPair(t)
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		device := newShellTransport(server, 0)
+		for device.Send(shellStdout, []byte("tick\n")) == nil {
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &ShellStream{streamState: newStreamState(ctx), lines: make(chan ShellLine)}
+	s.closeOnDone(client)
+	go func() {
+		defer close(s.lines)
+		s.finish(s.readShellProtocol(transport{sock: client}))
+	}()
+
+	<-s.Lines()
+	cancel()
+	for range s.Lines() {
+	}
+	if err := s.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	// stop the device side before the test ends
+	_ = server.Close()
+	<-sent
+}
@@ -108,6 +108,15 @@ func (t transport) Close() (err error) {
 	return t.sock.Close()
 }
 
+// CloseWrite shuts down the writing side of the connection, signalling EOF
+// to the remote service while its output can still be read.
+func (t transport) CloseWrite() (err error) {
+	if cw, ok := t.sock.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
 func (t transport) CreateSyncTransport() (sTp syncTransport, err error) {
 	if err = t.Send("sync:"); err != nil {
 		return syncTransport{}, err
@@ -0,0 +1,217 @@
+package gadb
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UINode is an element of the view hierarchy dumped by uiautomator.
+type UINode struct {
+	Class       string
+	Package     string
+	Text        string
+	ResourceID  string
+	ContentDesc string
+	// Bounds is the element's rectangle in screen pixels.
+	Bounds image.Rectangle
+	// Index is the position among the parent's children.
+	Index int
+
+	Checkable, Checked       bool
+	Clickable, LongClickable bool
+	Enabled, Focusable       bool
+	Focused, Selected        bool
+	Scrollable, Password     bool
+
+	// Attrs holds every attribute as dumped, e.g. "resource-id".
+	Attrs map[string]string
+
+	Parent   *UINode
+	Children []*UINode
+}
+
+// Center returns the midpoint of the node's bounds.
+func (n *UINode) Center() image.Point {
+	return image.Pt((n.Bounds.Min.X+n.Bounds.Max.X)/2, (n.Bounds.Min.Y+n.Bounds.Max.Y)/2)
+}
+
+// Walk calls fn for n and its descendants in document order, skipping the
+// children of nodes for which fn returns false.
+func (n *UINode) Walk(fn func(*UINode) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, child := range n.Children {
+		child.Walk(fn)
+	}
+}
+
+// Find returns the descendants of n, and n itself, matching fn.
+func (n *UINode) Find(fn func(*UINode) bool) []*UINode {
+	var found []*UINode
+	n.Walk(func(node *UINode) bool {
+		if fn(node) {
+			found = append(found, node)
+		}
+		return true
+	})
+	return found
+}
+
+// FindByText returns the nodes whose text is text.
+func (n *UINode) FindByText(text string) []*UINode {
+	return n.Find(func(node *UINode) bool { return node.Text == text })
+}
+
+// FindByResourceID returns the nodes with the given resource ID, either
+// fully qualified ("com.example:id/ok") or just its entry name ("ok").
+func (n *UINode) FindByResourceID(id string) []*UINode {
+	return n.Find(func(node *UINode) bool {
+		return node.ResourceID == id || strings.HasSuffix(node.ResourceID, ":id/"+id)
+	})
+}
+
+// UIHierarchy dumps the current view hierarchy with `uiautomator dump`.
+// The returned root is the dump's <hierarchy> element; its children are
+// the windows' root views.
+func (d Device) UIHierarchy(ctx context.Context) (*UINode, error) {
+	data, err := d.uiautomatorDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseUIHierarchy(data)
+}
+
+// uiautomatorDump returns the XML written by `uiautomator dump`. Writing
+// to /dev/tty avoids a round trip through storage, but needs a terminal,
+// which some devices do not give exec: commands, so the dump falls back to
+// a temporary file.
+func (d Device) uiautomatorDump(ctx context.Context) ([]byte, error) {
+	out, err := d.execOutput(ctx, "uiautomator dump /dev/tty")
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := uiautomatorXML(out); ok {
+		return data, nil
+	}
+	debugLog("uiautomator dump /dev/tty: " + strings.TrimSpace(string(out)) + ", falling back to a file")
+
+	remotePath := fmt.Sprintf("/data/local/tmp/gadb-%d.xml", time.Now().UnixNano())
+	defer func() { _, _ = d.RunShellCommand("rm", "-f", ShellQuote(remotePath)) }()
+	if out, err = d.execOutput(ctx, "uiautomator dump "+ShellQuote(remotePath)); err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(out, []byte("dumped to")) {
+		return nil, fmt.Errorf("uiautomator dump: %s", strings.TrimSpace(string(out)))
+	}
+	var buf bytes.Buffer
+	if err = d.Pull(remotePath, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d Device) execOutput(ctx context.Context, cmd string) ([]byte, error) {
+	r, err := d.ExecOutReader(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return ioutil.ReadAll(r)
+}
+
+// uiautomatorXML extracts the hierarchy from the output of a dump to
+// /dev/tty, which is followed by a "UI hierchary dumped to" message.
+func uiautomatorXML(out []byte) ([]byte, bool) {
+	start := bytes.Index(out, []byte("<?xml"))
+	end := bytes.LastIndex(out, []byte("</hierarchy>"))
+	if start < 0 || end < start {
+		return nil, false
+	}
+	return out[start : end+len("</hierarchy>")], true
+}
+
+var uiBounds = regexp.MustCompile(`^\[(-?\d+),(-?\d+)\]\[(-?\d+),(-?\d+)\]$`)
+
+// parseUIHierarchy parses a uiautomator dump, whose elements look like
+//
+//	<node index="0" text="OK" resource-id="android:id/button1" class="android.widget.Button"
+//	    package="com.example" content-desc="" checkable="false" checked="false" clickable="true"
+//	    enabled="true" ... bounds="[788,1296][1001,1422]">
+func parseUIHierarchy(data []byte) (*UINode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root, current *UINode
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if root != nil && current == nil {
+				return root, nil
+			}
+			return nil, fmt.Errorf("parse ui hierarchy: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if current == nil && root != nil {
+				return nil, errors.New("parse ui hierarchy: multiple root elements")
+			}
+			node := newUINode(tok)
+			if current == nil {
+				root = node
+			} else {
+				node.Parent = current
+				current.Children = append(current.Children, node)
+			}
+			current = node
+		case xml.EndElement:
+			current = current.Parent
+		}
+	}
+}
+
+func newUINode(elem xml.StartElement) *UINode {
+	node := &UINode{Attrs: make(map[string]string, len(elem.Attr))}
+	for _, a := range elem.Attr {
+		node.Attrs[a.Name.Local] = a.Value
+	}
+	node.Class = node.Attrs["class"]
+	node.Package = node.Attrs["package"]
+	node.Text = node.Attrs["text"]
+	node.ResourceID = node.Attrs["resource-id"]
+	node.ContentDesc = node.Attrs["content-desc"]
+	node.Index, _ = strconv.Atoi(node.Attrs["index"])
+	if m := uiBounds.FindStringSubmatch(node.Attrs["bounds"]); m != nil {
+		var v [4]int
+		for i := range v {
+			v[i], _ = strconv.Atoi(m[i+1])
+		}
+		node.Bounds = image.Rect(v[0], v[1], v[2], v[3])
+	}
+	for name, field := range map[string]*bool{
+		"checkable": &node.Checkable, "checked": &node.Checked,
+		"clickable": &node.Clickable, "long-clickable": &node.LongClickable,
+		"enabled": &node.Enabled, "focusable": &node.Focusable,
+		"focused": &node.Focused, "selected": &node.Selected,
+		"scrollable": &node.Scrollable, "password": &node.Password,
+	} {
+		*field = node.Attrs[name] == "true"
+	}
+	return node
+}
+
+// TapElement taps the center of a node.
+func (d Device) TapElement(node *UINode, opts ...InputOptions) error {
+	if node.Bounds.Empty() {
+		return fmt.Errorf("tap element %s: empty bounds", node.Class)
+	}
+	center := node.Center()
+	return d.Tap(center.X, center.Y, opts...)
+}
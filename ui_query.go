@@ -0,0 +1,263 @@
+package gadb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query selects nodes with a subset of XPath, evaluated with n as the
+// document root:
+//
+//	//node[@resource-id='com.example:id/ok']
+//	//android.widget.Button[@text='OK' and @enabled='true']
+//	/hierarchy/node[1]//TextView[contains(@text,'Settings')]
+//	//*[@clickable][last()]
+//
+// Steps are / (child) or // (descendant). A step's name matches a node's
+// class, fully qualified or not; "node" and "*" match any node, and
+// "hierarchy" the root. Predicates are @attr (attribute is "true"),
+// @attr='value', contains(@attr,'value'), starts-with(@attr,'value'), a
+// 1-based position or last(), combined with "and".
+func (n *UINode) Query(expr string) ([]*UINode, error) {
+	steps, err := parseUIQuery(expr)
+	if err != nil {
+		return nil, fmt.Errorf("ui query %q: %w", expr, err)
+	}
+
+	// the document node, whose only child is the root element
+	doc := &UINode{Children: []*UINode{n}}
+	current := []*UINode{doc}
+	for _, step := range steps {
+		var next []*UINode
+		seen := map[*UINode]bool{}
+		for _, ctx := range current {
+			parents := []*UINode{ctx}
+			if step.descendant {
+				parents = ctx.Find(func(*UINode) bool { return true })
+			}
+			for _, parent := range parents {
+				for _, node := range step.apply(parent.Children, n) {
+					if !seen[node] {
+						seen[node] = true
+						next = append(next, node)
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// QueryOne returns the first node selected by expr, or nil if none is.
+func (n *UINode) QueryOne(expr string) (*UINode, error) {
+	nodes, err := n.Query(expr)
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+type uiQueryStep struct {
+	descendant bool
+	name       string
+	predicates [][]uiPredicate // each a conjunction
+}
+
+// uiPredicate is one term of a predicate: a position, or a test of an
+// attribute.
+type uiPredicate struct {
+	position int // 1-based; -1 for last()
+	attr     string
+	op       string // "", "=", "contains" or "starts-with"
+	value    string
+}
+
+// apply filters candidates, the children of one parent, by the step.
+func (s uiQueryStep) apply(candidates []*UINode, root *UINode) []*UINode {
+	var matched []*UINode
+	for _, node := range candidates {
+		if s.matchName(node, root) {
+			matched = append(matched, node)
+		}
+	}
+	for _, conj := range s.predicates {
+		var kept []*UINode
+		for i, node := range matched {
+			if matchUIPredicates(conj, node, i+1, len(matched)) {
+				kept = append(kept, node)
+			}
+		}
+		matched = kept
+	}
+	return matched
+}
+
+func (s uiQueryStep) matchName(node, root *UINode) bool {
+	switch s.name {
+	case "*":
+		return true
+	case "hierarchy":
+		return node == root
+	case "node":
+		return node != root
+	}
+	return node.Class == s.name || strings.HasSuffix(node.Class, "."+s.name)
+}
+
+func matchUIPredicates(conj []uiPredicate, node *UINode, position, size int) bool {
+	for _, p := range conj {
+		var ok bool
+		switch {
+		case p.position == -1:
+			ok = position == size
+		case p.position > 0:
+			ok = position == p.position
+		case p.op == "":
+			ok = node.Attrs[p.attr] == "true"
+		case p.op == "=":
+			v, present := node.Attrs[p.attr]
+			ok = present && v == p.value
+		case p.op == "contains":
+			ok = strings.Contains(node.Attrs[p.attr], p.value)
+		case p.op == "starts-with":
+			ok = strings.HasPrefix(node.Attrs[p.attr], p.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseUIQuery splits expr into steps.
+func parseUIQuery(expr string) ([]uiQueryStep, error) {
+	if !strings.HasPrefix(expr, "/") {
+		return nil, errors.New("must start with / or //")
+	}
+	var steps []uiQueryStep
+	for i := 0; i < len(expr); {
+		if expr[i] != '/' {
+			return nil, fmt.Errorf("unexpected %q at offset %d", expr[i], i)
+		}
+		var step uiQueryStep
+		i++
+		if i < len(expr) && expr[i] == '/' {
+			step.descendant = true
+			i++
+		}
+		start := i
+		for i < len(expr) && expr[i] != '/' && expr[i] != '[' {
+			i++
+		}
+		if step.name = strings.TrimSpace(expr[start:i]); step.name == "" {
+			return nil, fmt.Errorf("missing name at offset %d", start)
+		}
+		for i < len(expr) && expr[i] == '[' {
+			end := uiPredicateEnd(expr, i+1)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated predicate at offset %d", i)
+			}
+			conj, err := parseUIPredicate(expr[i+1 : end])
+			if err != nil {
+				return nil, err
+			}
+			step.predicates = append(step.predicates, conj)
+			i = end + 1
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// uiPredicateEnd returns the index of the ] closing a predicate starting
+// at i, skipping quoted strings.
+func uiPredicateEnd(expr string, i int) int {
+	var quote byte
+	for ; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseUIPredicate(s string) ([]uiPredicate, error) {
+	var conj []uiPredicate
+	for _, term := range splitUIAnd(s) {
+		term = strings.TrimSpace(term)
+		var p uiPredicate
+		switch {
+		case term == "last()":
+			p.position = -1
+		case strings.HasPrefix(term, "@"):
+			name := term[1:]
+			if eq := strings.IndexByte(term, '='); eq >= 0 {
+				value, err := unquoteUIValue(term[eq+1:])
+				if err != nil {
+					return nil, err
+				}
+				name, p.op, p.value = term[1:eq], "=", value
+			}
+			p.attr = strings.TrimSpace(name)
+		case strings.HasPrefix(term, "contains(") || strings.HasPrefix(term, "starts-with("):
+			open := strings.IndexByte(term, '(')
+			args := strings.SplitN(strings.TrimSuffix(term[open+1:], ")"), ",", 2)
+			if !strings.HasSuffix(term, ")") || len(args) != 2 || !strings.HasPrefix(strings.TrimSpace(args[0]), "@") {
+				return nil, fmt.Errorf("invalid predicate %q", term)
+			}
+			value, err := unquoteUIValue(args[1])
+			if err != nil {
+				return nil, err
+			}
+			p.op, p.attr, p.value = term[:open], strings.TrimSpace(args[0])[1:], value
+		default:
+			n, err := strconv.Atoi(term)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid predicate %q", term)
+			}
+			p.position = n
+		}
+		conj = append(conj, p)
+	}
+	return conj, nil
+}
+
+// splitUIAnd splits a predicate on "and" outside quoted strings.
+func splitUIAnd(s string) []string {
+	var terms []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], " and "):
+			terms = append(terms, s[start:i])
+			start = i + len(" and ")
+			i = start - 1
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func unquoteUIValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	return s[1 : len(s)-1], nil
+}
@@ -0,0 +1,105 @@
+package gadb
+
+import (
+	"image"
+	"testing"
+)
+
+const testUIDump = `<?xml version='1.0' encoding='UTF-8' standalone='yes' ?><hierarchy rotation="0"><node index="0" text="" resource-id="" class="android.widget.FrameLayout" package="com.example" content-desc="" checkable="false" checked="false" clickable="false" enabled="true" focusable="false" focused="false" scrollable="false" long-clickable="false" password="false" selected="false" bounds="[0,0][1080,2400]"><node index="0" text="Settings" resource-id="com.example:id/title" class="android.widget.TextView" package="com.example" content-desc="" checkable="false" checked="false" clickable="false" enabled="true" focusable="false" focused="false" scrollable="false" long-clickable="false" password="false" selected="false" bounds="[40,100][600,180]" /><node index="1" text="Wi-Fi" resource-id="com.example:id/wifi" class="android.widget.Switch" package="com.example" content-desc="Toggle Wi-Fi" checkable="true" checked="true" clickable="true" enabled="true" focusable="true" focused="false" scrollable="false" long-clickable="false" password="false" selected="false" bounds="[40,200][1040,300]" /><node index="2" text="OK" resource-id="android:id/button1" class="android.widget.Button" package="com.example" content-desc="" checkable="false" checked="false" clickable="true" enabled="true" focusable="true" focused="false" scrollable="false" long-clickable="false" password="false" selected="false" bounds="[788,1296][1001,1422]" /></node></hierarchy>
+UI hierchary dumped to: /dev/tty
+`
+
+func testUIHierarchy(t *testing.T) *UINode {
+	t.Helper()
+	data, ok := uiautomatorXML([]byte(testUIDump))
+	if !ok {
+		t.Fatal("uiautomatorXML() found no hierarchy")
+	}
+	root, err := parseUIHierarchy(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func Test_parseUIHierarchy(t *testing.T) {
+	root := testUIHierarchy(t)
+	if root.Attrs["rotation"] != "0" || len(root.Children) != 1 {
+		t.Fatalf("root = %+v", root)
+	}
+	frame := root.Children[0]
+	if len(frame.Children) != 3 || frame.Parent != root {
+		t.Fatalf("frame has %d children", len(frame.Children))
+	}
+	wifi := frame.Children[1]
+	if wifi.Class != "android.widget.Switch" || wifi.Text != "Wi-Fi" || wifi.ContentDesc != "Toggle Wi-Fi" ||
+		wifi.ResourceID != "com.example:id/wifi" || wifi.Index != 1 {
+		t.Errorf("wifi = %+v", wifi)
+	}
+	if !wifi.Checkable || !wifi.Checked || !wifi.Clickable || wifi.Scrollable {
+		t.Errorf("wifi flags = %+v", wifi)
+	}
+	if want := image.Rect(40, 200, 1040, 300); wifi.Bounds != want {
+		t.Errorf("wifi bounds = %v, want %v", wifi.Bounds, want)
+	}
+	if want := image.Pt(894, 1359); frame.Children[2].Center() != want {
+		t.Errorf("button center = %v, want %v", frame.Children[2].Center(), want)
+	}
+}
+
+func TestUINode_Find(t *testing.T) {
+	root := testUIHierarchy(t)
+	if nodes := root.FindByText("OK"); len(nodes) != 1 || nodes[0].Class != "android.widget.Button" {
+		t.Errorf("FindByText() = %v", nodes)
+	}
+	if nodes := root.FindByResourceID("title"); len(nodes) != 1 || nodes[0].Text != "Settings" {
+		t.Errorf("FindByResourceID(title) = %v", nodes)
+	}
+	if nodes := root.FindByResourceID("android:id/button1"); len(nodes) != 1 {
+		t.Errorf("FindByResourceID(android:id/button1) = %v", nodes)
+	}
+}
+
+func TestUINode_Query(t *testing.T) {
+	root := testUIHierarchy(t)
+	tests := []struct {
+		expr string
+		want []string // texts
+	}{
+		{"//node[@clickable]", []string{"Wi-Fi", "OK"}},
+		{"//Button[@text='OK' and @enabled='true']", []string{"OK"}},
+		{"/hierarchy/node/node[2]", []string{"Wi-Fi"}},
+		{"/hierarchy/*/node[last()]", []string{"OK"}},
+		{"//android.widget.TextView[contains(@text,'ett')]", []string{"Settings"}},
+		{"//*[starts-with(@resource-id,'com.example:id/')][@checkable]", []string{"Wi-Fi"}},
+		{"//node[@resource-id='android:id/button1']", []string{"OK"}},
+		{"//node[@text='Missing']", nil},
+	}
+	for _, tt := range tests {
+		nodes, err := root.Query(tt.expr)
+		if err != nil {
+			t.Errorf("Query(%q): %v", tt.expr, err)
+			continue
+		}
+		var got []string
+		for _, n := range nodes {
+			got = append(got, n.Text)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("Query(%q) = %q, want %q", tt.expr, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Query(%q) = %q, want %q", tt.expr, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, expr := range []string{"node", "//node[", "//node[@text=OK]", "//node[0]", "//"} {
+		if _, err := root.Query(expr); err == nil {
+			t.Errorf("Query(%q) succeeded", expr)
+		}
+	}
+}